@@ -2,12 +2,16 @@ package bagcreate
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
-	gobagit "github.com/nyudlts/go-bagit"
 	cp "github.com/otiai10/copy"
 	"go.artefactual.dev/tools/fsutil"
 	"go.artefactual.dev/tools/temporal"
@@ -28,10 +32,64 @@ type (
 		// then the Bag will be created at SourcePath, replacing the original
 		// directory contents.
 		BagPath string
+
+		// Move moves the SourcePath files into the Bag created at BagPath
+		// instead of copying them, removing SourcePath once the Bag is created.
+		// Files are hard linked when SourcePath and BagPath are on the same
+		// filesystem and copied otherwise. Move is ignored if BagPath is empty.
+		Move bool
+
+		// SerializeFormat is the archive format used to serialize the Bag, valid
+		// values are "tar", "tar.gz" and "zip". The archive is created next to
+		// the Bag, named after it, and has a single top-level directory with the
+		// Bag name. If SerializeFormat is empty, the Bag is not serialized.
+		SerializeFormat string
+
+		// Update updates the Bag if SourcePath is already a Bag, rescanning its
+		// data directory to regenerate the manifests, the Payload-Oxum and the
+		// tag manifests, and keeping the other bag-info.txt tags. If Update is
+		// false, an existing Bag is returned unchanged.
+		Update bool
+
+		// Checksums maps the paths of the SourcePath files, relative to
+		// SourcePath (e.g. "dir/file.txt"), to their known checksums, generated
+		// with the configured ChecksumAlgorithm as hex digests. The known
+		// checksums are written to the Bag manifest without reading the files
+		// again, and only the files missing from Checksums are read to
+		// generate theirs.
+		Checksums map[string]string
+
+		// ChecksumsPath is the path of a manifest file with the known checksums
+		// of the SourcePath files, with a "<checksum> <path>" line per file and
+		// the same paths and algorithm as Checksums. Entries in Checksums take
+		// precedence over the ones in ChecksumsPath.
+		ChecksumsPath string
+
+		// SpotCheck is the number of files with a known checksum, chosen at
+		// random, that are read to verify their checksum. The Bag creation
+		// fails if any of them doesn't match. If SpotCheck is zero, the known
+		// checksums are not verified.
+		SpotCheck int
 	}
 	Result struct {
 		// BagPath of the path to the created Bag.
 		BagPath string
+
+		// ArchivePath is the path of the serialized Bag, it will be empty if no
+		// SerializeFormat was given.
+		ArchivePath string
+
+		// ArchiveChecksum is the checksum of the serialized Bag, generated with
+		// the configured ChecksumAlgorithm.
+		ArchiveChecksum string
+
+		// Added, Removed and Changed list the paths of the payload files (e.g.
+		// "data/file.txt") added, removed or changed since the Bag manifests
+		// were last generated. They are only set when an existing Bag is
+		// updated.
+		Added   []string
+		Removed []string
+		Changed []string
 	}
 	Activity struct {
 		cfg *Config
@@ -50,44 +108,166 @@ func New(cfg Config) *Activity {
 // If BagPath is empty, then the Bag will be created at SourcePath, replacing
 // the original directory contents. In either case the path of the Bag is
 // returned.
+//
+// If SourcePath is already a Bag, it's returned unchanged unless Update is
+// set, in which case the Bag manifests and Payload-Oxum are regenerated and
+// the added, removed and changed payload files are returned.
+//
+// If SerializeFormat is set, the Bag is also serialized to an archive and the
+// archive path and checksum are returned.
 func (a *Activity) Execute(ctx context.Context, params *Params) (*Result, error) {
 	logger := temporal.GetLogger(ctx)
 	logger.V(1).Info("Executing bag-create activity", "SourcePath", params.SourcePath)
 
+	if params.SerializeFormat != "" && !slices.Contains(serializationFormats, params.SerializeFormat) {
+		return nil, fmt.Errorf(
+			"bagcreate: SerializeFormat: invalid value %q, must be one of (%s)",
+			params.SerializeFormat,
+			strings.Join(serializationFormats, ", "),
+		)
+	}
+
+	res := &Result{BagPath: params.SourcePath}
+
 	// Check if directory is already a Bag
-	if _, err := os.Stat(filepath.Join(params.SourcePath, "bagit.txt")); err == nil {
-		return &Result{BagPath: params.SourcePath}, nil
+	if _, err := os.Stat(filepath.Join(params.SourcePath, "bagit.txt")); err != nil {
+		checksums, err := knownChecksums(params.Checksums, params.ChecksumsPath, a.cfg.ChecksumAlgorithm)
+		if err != nil {
+			return nil, fmt.Errorf("bagcreate: %v", err)
+		}
+
+		res.BagPath, err = a.create(params, checksums)
+		if err != nil {
+			return nil, fmt.Errorf("bagcreate: %v", err)
+		}
+	} else if params.Update {
+		c, err := a.update(params.SourcePath)
+		if err != nil {
+			return nil, fmt.Errorf("bagcreate: update bag: %v", err)
+		}
+		if err := fsutil.SetFileModes(params.SourcePath, dirMode, fileMode); err != nil {
+			return nil, fmt.Errorf("bagcreate: set file modes: %v", err)
+		}
+		res.Added, res.Removed, res.Changed = c.added, c.removed, c.changed
 	}
 
-	dest, err := a.create(params.SourcePath, params.BagPath)
-	if err != nil {
-		return nil, fmt.Errorf("bagcreate: %v", err)
+	if params.SerializeFormat != "" {
+		path, checksum, err := serialize(res.BagPath, params.SerializeFormat, a.cfg.ChecksumAlgorithm)
+		if err != nil {
+			return nil, fmt.Errorf("bagcreate: serialize bag: %v", err)
+		}
+		res.ArchivePath = path
+		res.ArchiveChecksum = checksum
 	}
 
-	return &Result{BagPath: dest}, nil
+	return res, nil
 }
 
-// create creates a BagIt Bag at dest from the files at src. If dest is empty,
-// the BagIt Bag is created in-place at src.
-func (a *Activity) create(src, dest string) (string, error) {
+// create creates a BagIt Bag at params.BagPath from the files at
+// params.SourcePath, using the known checksums for the payload manifest. If
+// params.BagPath is empty, the BagIt Bag is created in-place at
+// params.SourcePath. If params.Move is true, the source files are linked (or
+// copied) to params.BagPath and params.SourcePath is removed after the Bag is
+// created.
+//
+// If the Bag creation fails, the source and Bag paths are restored to their
+// original layout.
+func (a *Activity) create(params *Params, checksums map[string]string) (string, error) {
+	src, dest, move := params.SourcePath, params.BagPath, params.Move
 	if dest == "" {
 		dest = src
-	} else {
-		if err := cp.Copy(src, dest); err != nil {
-			return "", fmt.Errorf("copy source dir to bag path: %v", err)
-		}
 	}
 
-	// CreateBag currently only runs a single process to create a bag. If this
-	// changes in the future we should add a config value to set numProcesses.
-	_, err := gobagit.CreateBag(dest, a.cfg.ChecksumAlgorithm, 1)
+	// Record the entries at dest before the Bag is created, so they can be
+	// restored on failure.
+	_, err := os.Stat(dest)
+	newDest := errors.Is(err, fs.ErrNotExist)
+	entries, err := dirNames(dest)
 	if err != nil {
-		return "", fmt.Errorf("create bag: %v", err)
+		return "", fmt.Errorf("read bag path: %v", err)
+	}
+	// rollback restores the original layout, joining any restore error to
+	// err so the user knows the source files may be left in the Bag path.
+	rollback := func(err error) error {
+		var rerr error
+		if newDest {
+			rerr = os.RemoveAll(dest)
+		} else {
+			rerr = restore(dest, entries)
+		}
+		if rerr != nil {
+			return errors.Join(err, fmt.Errorf("restore: %v", rerr))
+		}
+		return err
+	}
+
+	if dest != src {
+		if move {
+			if err := linkTree(src, dest); err != nil {
+				return "", rollback(fmt.Errorf("link source dir to bag path: %v", err))
+			}
+		} else {
+			if err := cp.Copy(src, dest); err != nil {
+				return "", rollback(fmt.Errorf("copy source dir to bag path: %v", err))
+			}
+		}
+	}
+
+	if err := a.createBag(dest, checksums, params.SpotCheck); err != nil {
+		return "", rollback(err)
 	}
 
-	if err := fsutil.SetFileModes(dest, dirMode, fileMode); err != nil {
-		return "", fmt.Errorf("set file modes: %v", err)
+	if dest != src && move {
+		if err := os.RemoveAll(src); err != nil {
+			return "", fmt.Errorf("remove source dir: %v", err)
+		}
 	}
 
 	return dest, nil
 }
+
+// createBag creates a BagIt Bag in-place at path, moving the files at path
+// into the Bag data directory.
+func (a *Activity) createBag(path string, checksums map[string]string, spotCheck int) error {
+	if err := writeBag(path, a.cfg.ChecksumAlgorithm, checksums, spotCheck); err != nil {
+		return fmt.Errorf("create bag: %v", err)
+	}
+
+	if err := fsutil.SetFileModes(path, dirMode, fileMode); err != nil {
+		return fmt.Errorf("set file modes: %v", err)
+	}
+
+	return nil
+}
+
+// knownChecksums merges the checksums from the manifest file at path, if not
+// empty, with the given checksums, which take precedence. It returns an error
+// if any of the checksums isn't a valid alg hex digest.
+func knownChecksums(checksums map[string]string, path, alg string) (map[string]string, error) {
+	known := maps.Clone(checksums)
+	if path != "" {
+		var err error
+		known, err = readManifest(path)
+		if err != nil {
+			return nil, fmt.Errorf("read checksums: %v", err)
+		}
+		maps.Copy(known, checksums)
+	}
+
+	h, err := newHash(alg)
+	if err != nil {
+		return nil, err
+	}
+	var invalid []string
+	for p, sum := range known {
+		if b, err := hex.DecodeString(sum); err != nil || len(b) != h.Size() {
+			invalid = append(invalid, p)
+		}
+	}
+	if len(invalid) > 0 {
+		slices.Sort(invalid)
+		return nil, fmt.Errorf("invalid %s checksums for files: %s", alg, strings.Join(invalid, ", "))
+	}
+
+	return known, nil
+}
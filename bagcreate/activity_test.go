@@ -1,7 +1,19 @@
 package bagcreate_test
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
 	temporalsdk_activity "go.temporal.io/sdk/activity"
@@ -19,6 +31,8 @@ const (
 	sha256manifest string = `5896fb5c3f2944f57c993fa06c130ff2c4182e4fea61c2597c52b0f9d437040e  data/another.txt
 4450c8a88130a3b397bfc659245c4f0f87a8c79d017a60bdb1bd32f4b51c8133  data/small.txt
 `
+	knownSmallChecksum string = "8cbdd4ed5452f7c066509c066d5ea87fc03f30b0c67153624a1bce4d6e14b6709b5e78caf723cdf419d0efad4db96ba1cad3196783c26a7743029459bdd148b0"
+
 	sha512manifest string = `946af3bfd3b0b84ea0d99136085dcd66ee7e769371dbcd097ed35fd377116087e25d004afd68dc48e4eb0bcb6a434b04078577b531a7da1452296d1ae98d20b3  data/another.txt
 8cbdd4ed5452f7c066509c066d5ea87fc03f30b0c67153624a1bce4d6e14b6709b5e78caf723cdf419d0efad4db96ba1cad3196783c26a7743029459bdd148b0  data/small.txt
 `
@@ -48,6 +62,39 @@ func sourcePath(t *testing.T) string {
 	return td.Path()
 }
 
+func brokenSourcePath(t *testing.T) string {
+	t.Helper()
+
+	td := tfs.NewDir(t, "sdps_bagit_create_test",
+		tfs.WithFile("small.txt", "I am a small file.\n"),
+		tfs.WithFile("another.txt", "I am another file.\n"),
+		tfs.WithSymlink("broken.txt", "missing.txt"),
+	)
+
+	return td.Path()
+}
+
+func brokenSourceManifest(t *testing.T, path string) tfs.Manifest {
+	return tfs.Expected(t,
+		tfs.WithFile("small.txt", "I am a small file.\n"),
+		tfs.WithFile("another.txt", "I am another file.\n"),
+		tfs.WithSymlink("broken.txt", filepath.Join(path, "missing.txt")),
+	)
+}
+
+// restoredManifest returns the expected manifest of a source dir created by
+// sourcePath or brokenSourcePath after it has been restored.
+func restoredManifest(t *testing.T, path string) tfs.Manifest {
+	if _, err := os.Lstat(filepath.Join(path, "broken.txt")); err == nil {
+		return brokenSourceManifest(t, path)
+	}
+
+	return tfs.Expected(t,
+		tfs.WithFile("small.txt", "I am a small file.\n"),
+		tfs.WithFile("another.txt", "I am another file.\n"),
+	)
+}
+
 func existingBagPath(t *testing.T) string {
 	t.Helper()
 
@@ -63,11 +110,13 @@ func TestActivity(t *testing.T) {
 	t.Parallel()
 
 	type test struct {
-		name    string
-		cfg     bagcreate.Config
-		params  bagcreate.Params
-		want    tfs.Manifest
-		wantErr string
+		name          string
+		cfg           bagcreate.Config
+		params        bagcreate.Params
+		want          tfs.Manifest
+		wantRestored  bool
+		sourceRemoved bool
+		wantErr       string
 	}
 	for _, tt := range []test{
 		{
@@ -85,6 +134,26 @@ func TestActivity(t *testing.T) {
 			},
 			want: testBagManifest(t),
 		},
+		{
+			name: "Creates a bag in a new dir moving the source files",
+			params: bagcreate.Params{
+				SourcePath: sourcePath(t),
+				BagPath:    tfs.NewDir(t, "sdps_bagit_create_test").Path(),
+				Move:       true,
+			},
+			want:          testBagManifest(t),
+			sourceRemoved: true,
+		},
+		{
+			name: "Creates a bag in a missing dir moving the source files",
+			params: bagcreate.Params{
+				SourcePath: sourcePath(t),
+				BagPath:    tfs.NewDir(t, "sdps_bagit_create_test").Join("bag"),
+				Move:       true,
+			},
+			want:          testBagManifest(t),
+			sourceRemoved: true,
+		},
 		{
 			name: "Creates a bag with SHA-256 checksums",
 			cfg:  bagcreate.Config{ChecksumAlgorithm: "sha256"},
@@ -103,6 +172,80 @@ func TestActivity(t *testing.T) {
 				),
 			),
 		},
+		{
+			name: "Creates a bag with known checksums",
+			params: bagcreate.Params{
+				SourcePath: sourcePath(t),
+				Checksums: map[string]string{
+					// Fake checksum, to check that it's not generated again.
+					"small.txt": strings.Repeat("AB", sha512.Size),
+				},
+			},
+			want: tfs.Expected(t,
+				tfs.WithFile("bag-info.txt", "", tfs.MatchAnyFileContent, tfs.WithMode(fileMode)),
+				tfs.WithFile("bagit.txt", "", tfs.MatchAnyFileContent, tfs.WithMode(fileMode)),
+				tfs.WithFile(
+					"manifest-sha512.txt",
+					strings.Replace(sha512manifest, knownSmallChecksum, strings.Repeat("ab", sha512.Size), 1),
+					tfs.WithMode(fileMode),
+				),
+				tfs.WithFile("tagmanifest-sha512.txt", "", tfs.MatchAnyFileContent, tfs.WithMode(fileMode)),
+				tfs.WithDir("data", tfs.WithMode(dirMode),
+					tfs.WithFile("small.txt", "I am a small file.\n", tfs.WithMode(fileMode)),
+					tfs.WithFile("another.txt", "I am another file.\n", tfs.WithMode(fileMode)),
+				),
+			),
+		},
+		{
+			name: "Creates a bag with known checksums from a file",
+			params: bagcreate.Params{
+				SourcePath: sourcePath(t),
+				BagPath:    tfs.NewDir(t, "sdps_bagit_create_test").Path(),
+				ChecksumsPath: tfs.NewFile(t, "sdps_bagit_create_test",
+					tfs.WithContent(knownSmallChecksum+"  small.txt\n"),
+				).Path(),
+				SpotCheck: 5,
+			},
+			want: testBagManifest(t),
+		},
+		{
+			name: "Errors if a known checksum doesn't match the spot check",
+			params: bagcreate.Params{
+				SourcePath: sourcePath(t),
+				Checksums: map[string]string{
+					"small.txt": strings.Repeat("ab", sha512.Size),
+				},
+				SpotCheck: 1,
+			},
+			wantRestored: true,
+			wantErr: fmt.Sprintf(
+				`bagcreate: create bag: spot check: sha512 checksum mismatch for "small.txt": expected %q, found %q`,
+				strings.Repeat("ab", sha512.Size),
+				knownSmallChecksum,
+			),
+		},
+		{
+			name: "Errors if a known checksum isn't a valid digest",
+			params: bagcreate.Params{
+				SourcePath: sourcePath(t),
+				Checksums: map[string]string{
+					"small.txt":   "ABC123",
+					"another.txt": strings.Repeat("zz", sha512.Size),
+				},
+			},
+			wantRestored: true,
+			wantErr:      "bagcreate: invalid sha512 checksums for files: another.txt, small.txt",
+		},
+		{
+			name: "Errors if a known checksum is given for a missing file",
+			params: bagcreate.Params{
+				SourcePath: sourcePath(t),
+				Checksums: map[string]string{
+					"missing.txt": knownSmallChecksum,
+				},
+			},
+			wantErr: "bagcreate: create bag: checksums given for missing files: missing.txt",
+		},
 		{
 			name: "Errors if source dir is empty",
 			params: bagcreate.Params{
@@ -110,6 +253,24 @@ func TestActivity(t *testing.T) {
 			},
 			wantErr: "activity error (type: bag-create, scheduledEventID: 0, startedEventID: 0, identity: ): bagcreate: create bag: could not create a bag, no files present in",
 		},
+		{
+			name: "Restores the source dir if bag creation fails in place",
+			params: bagcreate.Params{
+				SourcePath: brokenSourcePath(t),
+			},
+			wantRestored: true,
+			wantErr:      "activity error (type: bag-create, scheduledEventID: 0, startedEventID: 0, identity: ): bagcreate: create bag: open",
+		},
+		{
+			name: "Keeps the source dir if bag creation fails moving the source files",
+			params: bagcreate.Params{
+				SourcePath: brokenSourcePath(t),
+				BagPath:    tfs.NewDir(t, "sdps_bagit_create_test").Join("bag"),
+				Move:       true,
+			},
+			wantRestored: true,
+			wantErr:      "activity error (type: bag-create, scheduledEventID: 0, startedEventID: 0, identity: ): bagcreate: create bag: open",
+		},
 		{
 			name: "Errors if bag path isn't writable",
 			params: bagcreate.Params{
@@ -140,12 +301,24 @@ func TestActivity(t *testing.T) {
 			)
 
 			enc, err := env.ExecuteActivity(bagcreate.Name, tt.params)
+			if tt.wantRestored {
+				assert.Assert(t, tfs.Equal(tt.params.SourcePath, restoredManifest(t, tt.params.SourcePath)))
+			}
 			if tt.wantErr != "" {
 				assert.ErrorContains(t, err, tt.wantErr)
+				if tt.params.BagPath != "" && tt.params.Move {
+					_, err := os.Stat(tt.params.BagPath)
+					assert.Assert(t, errors.Is(err, fs.ErrNotExist))
+				}
 				return
 			}
 			assert.NilError(t, err)
 
+			if tt.sourceRemoved {
+				_, err := os.Stat(tt.params.SourcePath)
+				assert.Assert(t, errors.Is(err, fs.ErrNotExist))
+			}
+
 			var result bagcreate.Result
 			_ = enc.Get(&result)
 
@@ -156,3 +329,177 @@ func TestActivity(t *testing.T) {
 		})
 	}
 }
+
+func archiveEntries(t *testing.T, path, format string) []string {
+	t.Helper()
+
+	var names []string
+	switch format {
+	case "zip":
+		zr, err := zip.OpenReader(path)
+		assert.NilError(t, err)
+		defer zr.Close()
+
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+	default:
+		f, err := os.Open(path)
+		assert.NilError(t, err)
+		defer f.Close()
+
+		var r io.Reader = f
+		if format == "tar.gz" {
+			gzr, err := gzip.NewReader(f)
+			assert.NilError(t, err)
+			r = gzr
+		}
+
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			assert.NilError(t, err)
+			names = append(names, hdr.Name)
+		}
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+func TestActivitySerialize(t *testing.T) {
+	t.Parallel()
+
+	for _, format := range []string{"tar", "tar.gz", "zip"} {
+		t.Run("Serializes a bag to "+format, func(t *testing.T) {
+			t.Parallel()
+
+			ts := &temporalsdk_testsuite.WorkflowTestSuite{}
+			env := ts.NewTestActivityEnvironment()
+			env.RegisterActivityWithOptions(
+				bagcreate.New(bagcreate.Config{}).Execute,
+				temporalsdk_activity.RegisterOptions{Name: bagcreate.Name},
+			)
+
+			bagPath := tfs.NewDir(t, "sdps_bagit_create_test").Join("mybag")
+			enc, err := env.ExecuteActivity(bagcreate.Name, bagcreate.Params{
+				SourcePath:      sourcePath(t),
+				BagPath:         bagPath,
+				SerializeFormat: format,
+			})
+			assert.NilError(t, err)
+
+			var result bagcreate.Result
+			_ = enc.Get(&result)
+			assert.Equal(t, result.BagPath, bagPath)
+			assert.Equal(t, result.ArchivePath, bagPath+"."+format)
+			assert.Assert(t, tfs.Equal(result.BagPath, testBagManifest(t)))
+
+			blob, err := os.ReadFile(result.ArchivePath)
+			assert.NilError(t, err)
+			sum := sha512.Sum512(blob)
+			assert.Equal(t, result.ArchiveChecksum, hex.EncodeToString(sum[:]))
+
+			assert.DeepEqual(t, archiveEntries(t, result.ArchivePath, format), []string{
+				"mybag/",
+				"mybag/bag-info.txt",
+				"mybag/bagit.txt",
+				"mybag/data/",
+				"mybag/data/another.txt",
+				"mybag/data/small.txt",
+				"mybag/manifest-sha512.txt",
+				"mybag/tagmanifest-sha512.txt",
+			})
+		})
+	}
+
+	t.Run("Errors on an invalid format", func(t *testing.T) {
+		t.Parallel()
+
+		ts := &temporalsdk_testsuite.WorkflowTestSuite{}
+		env := ts.NewTestActivityEnvironment()
+		env.RegisterActivityWithOptions(
+			bagcreate.New(bagcreate.Config{}).Execute,
+			temporalsdk_activity.RegisterOptions{Name: bagcreate.Name},
+		)
+
+		_, err := env.ExecuteActivity(bagcreate.Name, bagcreate.Params{
+			SourcePath:      sourcePath(t),
+			SerializeFormat: "rar",
+		})
+		assert.ErrorContains(t, err, `bagcreate: SerializeFormat: invalid value "rar", must be one of (tar, tar.gz, zip)`)
+	})
+}
+
+func TestActivityUpdate(t *testing.T) {
+	t.Parallel()
+
+	td := tfs.NewDir(t, "sdps_bagit_update_test",
+		tfs.WithFile("bagit.txt", "BagIt-Version: 0.97\nTag-File-Character-Encoding: UTF-8\n"),
+		tfs.WithFile("bag-info.txt", "Source-Organization: Artefactual\nPayload-Oxum: 1.1\nContact-Name: Jane\n"),
+		tfs.WithFile("manifest-sha512.txt", `8cbdd4ed5452f7c066509c066d5ea87fc03f30b0c67153624a1bce4d6e14b6709b5e78caf723cdf419d0efad4db96ba1cad3196783c26a7743029459bdd148b0  data/small.txt
+0000000000000000000000000000000000000000000000000000000000000000  data/another.txt
+1111111111111111111111111111111111111111111111111111111111111111  data/removed.txt
+`),
+		tfs.WithFile("tagmanifest-sha512.txt", "2222  bagit.txt\n"),
+		tfs.WithDir("data",
+			tfs.WithFile("small.txt", "I am a small file.\n"),
+			tfs.WithFile("another.txt", "I am another file.\n"),
+			tfs.WithFile("new.txt", "I am a new file.\n"),
+		),
+	)
+
+	ts := &temporalsdk_testsuite.WorkflowTestSuite{}
+	env := ts.NewTestActivityEnvironment()
+	env.RegisterActivityWithOptions(
+		bagcreate.New(bagcreate.Config{}).Execute,
+		temporalsdk_activity.RegisterOptions{Name: bagcreate.Name},
+	)
+
+	enc, err := env.ExecuteActivity(bagcreate.Name, bagcreate.Params{
+		SourcePath: td.Path(),
+		Update:     true,
+	})
+	assert.NilError(t, err)
+
+	var result bagcreate.Result
+	_ = enc.Get(&result)
+	assert.DeepEqual(t, result, bagcreate.Result{
+		BagPath: td.Path(),
+		Added:   []string{"data/new.txt"},
+		Removed: []string{"data/removed.txt"},
+		Changed: []string{"data/another.txt"},
+	})
+
+	bagInfo := "Source-Organization: Artefactual\nPayload-Oxum: 55.3\nContact-Name: Jane\n"
+	manifest := fmt.Sprintf(
+		"946af3bfd3b0b84ea0d99136085dcd66ee7e769371dbcd097ed35fd377116087e25d004afd68dc48e4eb0bcb6a434b04078577b531a7da1452296d1ae98d20b3  data/another.txt\n"+
+			"%x  data/new.txt\n"+
+			"8cbdd4ed5452f7c066509c066d5ea87fc03f30b0c67153624a1bce4d6e14b6709b5e78caf723cdf419d0efad4db96ba1cad3196783c26a7743029459bdd148b0  data/small.txt\n",
+		sha512.Sum512([]byte("I am a new file.\n")),
+	)
+	assert.Assert(t, tfs.Equal(td.Path(), tfs.Expected(t,
+		tfs.WithFile("bagit.txt", "", tfs.MatchAnyFileContent, tfs.WithMode(fileMode)),
+		tfs.WithFile("bag-info.txt", bagInfo, tfs.WithMode(fileMode)),
+		tfs.WithFile("manifest-sha512.txt", manifest, tfs.WithMode(fileMode)),
+		tfs.WithFile("tagmanifest-sha512.txt", "", tfs.MatchAnyFileContent, tfs.WithMode(fileMode)),
+		tfs.WithDir("data", tfs.WithMode(dirMode),
+			tfs.WithFile("small.txt", "I am a small file.\n", tfs.WithMode(fileMode)),
+			tfs.WithFile("another.txt", "I am another file.\n", tfs.WithMode(fileMode)),
+			tfs.WithFile("new.txt", "I am a new file.\n", tfs.WithMode(fileMode)),
+		),
+	)))
+
+	// The tag manifest lists the regenerated tag files.
+	tagManifest, err := os.ReadFile(td.Join("tagmanifest-sha512.txt"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(tagManifest), fmt.Sprintf(
+		"%x  bag-info.txt\n%x  bagit.txt\n%x  manifest-sha512.txt\n",
+		sha512.Sum512([]byte(bagInfo)),
+		sha512.Sum512([]byte("BagIt-Version: 0.97\nTag-File-Character-Encoding: UTF-8\n")),
+		sha512.Sum512([]byte(manifest)),
+	))
+}
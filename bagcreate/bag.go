@@ -0,0 +1,173 @@
+package bagcreate
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	gobagit "github.com/nyudlts/go-bagit"
+)
+
+// writeBag creates a BagIt Bag in-place at bagPath, moving the files at
+// bagPath into the Bag data directory and generating the Bag tag files and
+// manifests.
+//
+// checksums maps file paths, relative to bagPath before the files are moved,
+// to their known checksums. Those checksums are written to the payload
+// manifest as-is, and only the files missing from checksums are read to
+// generate theirs. If spotCheck is greater than zero, that number of files
+// with a known checksum, chosen at random, are read to verify it.
+func writeBag(bagPath, alg string, checksums map[string]string, spotCheck int) error {
+	entries, err := os.ReadDir(bagPath)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("could not create a bag, no files present in %s", bagPath)
+	}
+
+	dataDir := filepath.Join(bagPath, "data")
+	if err := os.Mkdir(dataDir, dirMode); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.Rename(filepath.Join(bagPath, e.Name()), filepath.Join(dataDir, e.Name())); err != nil {
+			return err
+		}
+	}
+
+	manifest, oxum, err := payloadManifest(bagPath, alg, checksums, spotCheck)
+	if err != nil {
+		return err
+	}
+	if err := writeManifest(filepath.Join(bagPath, manifestName("manifest", alg)), manifest); err != nil {
+		return fmt.Errorf("write manifest: %v", err)
+	}
+
+	bagit := gobagit.CreateBagit()
+	if err := os.WriteFile(filepath.Join(bagPath, bagit.Filename), bagit.GetTagSetAsByteSlice(), fileMode); err != nil {
+		return fmt.Errorf("write %s: %v", bagit.Filename, err)
+	}
+
+	bagInfo := gobagit.CreateBagInfo(time.Now())
+	bagInfo.Tags[gobagit.StandardTags.PayloadOxum] = oxum
+	if err := os.WriteFile(filepath.Join(bagPath, bagInfo.Filename), bagInfo.GetTagSetAsByteSlice(), fileMode); err != nil {
+		return fmt.Errorf("write %s: %v", bagInfo.Filename, err)
+	}
+
+	if err := writeTagManifests(bagPath, []string{alg}); err != nil {
+		return fmt.Errorf("write tag manifest: %v", err)
+	}
+
+	return nil
+}
+
+// payloadManifest returns the payload manifest entries and the Payload-Oxum of
+// the Bag at bagPath, using the known checksums and generating the missing
+// ones. See writeBag for the checksums and spotCheck parameters.
+func payloadManifest(
+	bagPath, alg string,
+	checksums map[string]string,
+	spotCheck int,
+) (map[string]string, string, error) {
+	known := make(map[string]string, len(checksums))
+	for p, sum := range checksums {
+		known[path.Join("data", path.Clean(filepath.ToSlash(p)))] = strings.ToLower(sum)
+	}
+
+	var (
+		size    int64
+		count   int
+		checked []string
+	)
+	manifest := make(map[string]string)
+	err := filepath.WalkDir(filepath.Join(bagPath, "data"), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += fi.Size()
+		count++
+
+		rel, err := filepath.Rel(bagPath, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if sum, ok := known[rel]; ok {
+			manifest[rel] = sum
+			checked = append(checked, rel)
+			return nil
+		}
+
+		sums, err := fileChecksums(p, []string{alg})
+		if err != nil {
+			return err
+		}
+		manifest[rel] = sums[alg]
+
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	var missing []string
+	for p := range known {
+		if _, ok := manifest[p]; !ok {
+			missing = append(missing, strings.TrimPrefix(p, "data/"))
+		}
+	}
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		return nil, "", fmt.Errorf("checksums given for missing files: %s", strings.Join(missing, ", "))
+	}
+
+	if err := verifySample(bagPath, alg, manifest, checked, spotCheck); err != nil {
+		return nil, "", fmt.Errorf("spot check: %v", err)
+	}
+
+	return manifest, fmt.Sprintf("%d.%d", size, count), nil
+}
+
+// verifySample verifies the manifest checksums of n random files from paths,
+// reading them from the Bag at bagPath.
+func verifySample(bagPath, alg string, manifest map[string]string, paths []string, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	n = min(n, len(paths))
+
+	var errs []error
+	// The random sample doesn't need to be cryptographically secure.
+	for _, i := range rand.Perm(len(paths))[:n] { // #nosec G404
+		p := paths[i]
+		sums, err := fileChecksums(filepath.Join(bagPath, filepath.FromSlash(p)), []string{alg})
+		if err != nil {
+			return err
+		}
+		if sums[alg] != manifest[p] {
+			errs = append(errs, fmt.Errorf(
+				"%s checksum mismatch for %q: expected %q, found %q",
+				alg, strings.TrimPrefix(p, "data/"), manifest[p], sums[alg],
+			))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,61 @@
+package bagcreate
+
+import (
+	"crypto/md5"  // #nosec G501 -- md5 is a valid BagIt checksum algorithm.
+	"crypto/sha1" // #nosec G505 -- sha1 is a valid BagIt checksum algorithm.
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// newHash returns a new hash.Hash for the given checksum algorithm.
+func newHash(alg string) (hash.Hash, error) {
+	switch alg {
+	case "md5":
+		return md5.New(), nil // #nosec G401
+	case "sha1":
+		return sha1.New(), nil // #nosec G401
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm: %q", alg)
+	}
+}
+
+// fileChecksums returns the checksums of the file at path for each of the
+// given algorithms, reading the file only once.
+func fileChecksums(path string, algs []string) (map[string]string, error) {
+	hashes := make(map[string]hash.Hash, len(algs))
+	writers := make([]io.Writer, 0, len(algs))
+	for _, alg := range algs {
+		h, err := newHash(alg)
+		if err != nil {
+			return nil, err
+		}
+		hashes[alg] = h
+		writers = append(writers, h)
+	}
+
+	f, err := os.Open(path) // #nosec G304 -- trusted path.
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(io.MultiWriter(writers...), f); err != nil {
+		return nil, err
+	}
+
+	sums := make(map[string]string, len(algs))
+	for alg, h := range hashes {
+		sums[alg] = hex.EncodeToString(h.Sum(nil))
+	}
+
+	return sums, nil
+}
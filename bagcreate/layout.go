@@ -0,0 +1,107 @@
+package bagcreate
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+
+	cp "github.com/otiai10/copy"
+)
+
+// dirNames returns the names of the entries in dir. A missing dir has no
+// entries.
+func dirNames(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.Name()
+	}
+
+	return names, nil
+}
+
+// linkTree recreates the src directory tree in dest, hard linking the src
+// files when both paths are on the same filesystem and copying them
+// otherwise.
+func linkTree(src, dest string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+
+		if d.IsDir() {
+			return os.MkdirAll(target, dirMode)
+		}
+
+		if err := os.Link(path, target); err != nil {
+			if !errors.Is(err, syscall.EXDEV) {
+				return err
+			}
+			return cp.Copy(path, target)
+		}
+
+		return nil
+	})
+}
+
+// restore returns dir to the layout it had before a Bag creation attempt,
+// where names are the entries originally found in dir. Payload entries moved
+// into the Bag data directory are moved back, and any entry added to dir
+// during the attempt is removed. A data directory that still has content
+// after moving back the payload is left in place to avoid losing files.
+func restore(dir string, names []string) error {
+	dataDir := filepath.Join(dir, "data")
+
+	var errs []error
+	for _, name := range names {
+		orig := filepath.Join(dir, name)
+		if _, err := os.Lstat(orig); err == nil {
+			continue
+		}
+
+		if err := os.Rename(filepath.Join(dataDir, name), orig); err != nil {
+			errs = append(errs, fmt.Errorf("move %q back: %v", name, err))
+		}
+	}
+
+	current, err := dirNames(dir)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+
+	for _, name := range current {
+		if slices.Contains(names, name) {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		if name == "data" {
+			// Only remove the data directory if it's empty.
+			err = os.Remove(path)
+		} else {
+			err = os.RemoveAll(path)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("remove %q: %v", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
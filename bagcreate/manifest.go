@@ -0,0 +1,120 @@
+package bagcreate
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// manifestAlgorithms returns the checksum algorithms of the manifest files in
+// the Bag at bagPath with the given prefix ("manifest" or "tagmanifest").
+func manifestAlgorithms(bagPath, prefix string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(bagPath, prefix+"-*.txt"))
+	if err != nil {
+		return nil, err
+	}
+
+	algs := make([]string, 0, len(matches))
+	for _, m := range matches {
+		alg := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(m), prefix+"-"), ".txt")
+		algs = append(algs, alg)
+	}
+	slices.Sort(algs)
+
+	return algs, nil
+}
+
+// manifestName returns the name of a manifest file.
+func manifestName(prefix, alg string) string {
+	return fmt.Sprintf("%s-%s.txt", prefix, alg)
+}
+
+// readManifest returns the entries of the manifest file at path as a map of
+// file paths to checksums.
+func readManifest(path string) (map[string]string, error) {
+	f, err := os.Open(path) // #nosec G304 -- trusted path.
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make(map[string]string)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+
+		checksum, p, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid manifest line: %q", line)
+		}
+		entries[strings.TrimSpace(p)] = strings.ToLower(checksum)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// writeManifest writes entries to the manifest file at path, sorted by file
+// path, replacing any existing content.
+func writeManifest(path string, entries map[string]string) error {
+	paths := make([]string, 0, len(entries))
+	for p := range entries {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+
+	var b bytes.Buffer
+	for _, p := range paths {
+		fmt.Fprintf(&b, "%s  %s\n", entries[p], p)
+	}
+
+	return os.WriteFile(path, b.Bytes(), fileMode)
+}
+
+// setTag sets the value of the label tag in the tag file content b, keeping
+// the rest of the tags and their order. If the tag is repeated only its first
+// occurrence is kept, and if it's missing it's appended.
+func setTag(b []byte, label, value string) []byte {
+	var (
+		out   bytes.Buffer
+		found bool
+		skip  bool
+	)
+	for line := range strings.Lines(string(b)) {
+		// Continuation lines start with a space or tab.
+		if skip && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			continue
+		}
+		skip = false
+
+		name, _, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), label) {
+			skip = true
+			if found {
+				continue
+			}
+			found = true
+			fmt.Fprintf(&out, "%s: %s\n", label, value)
+			continue
+		}
+
+		out.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			out.WriteString("\n")
+		}
+	}
+	if !found {
+		fmt.Fprintf(&out, "%s: %s\n", label, value)
+	}
+
+	return out.Bytes()
+}
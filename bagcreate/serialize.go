@@ -0,0 +1,175 @@
+package bagcreate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+var serializationFormats = []string{"tar", "tar.gz", "zip"}
+
+// archiveWriter adds files and directories to an archive.
+type archiveWriter interface {
+	add(name string, fi fs.FileInfo, r io.Reader) error
+	Close() error
+}
+
+// serialize writes the Bag at bagPath to an archive in the given format, next
+// to bagPath and named after it, with a single top-level directory containing
+// the Bag files. It returns the archive path and its checksum, generated with
+// the alg algorithm.
+func serialize(bagPath, format, alg string) (string, string, error) {
+	h, err := newHash(alg)
+	if err != nil {
+		return "", "", err
+	}
+
+	dest := filepath.Clean(bagPath) + "." + format
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fileMode) // #nosec G304 -- trusted path.
+	if err != nil {
+		return "", "", fmt.Errorf("create archive: %v", err)
+	}
+
+	if err := writeArchive(io.MultiWriter(f, h), bagPath, format); err != nil {
+		_ = f.Close()
+		_ = os.Remove(dest)
+		return "", "", err
+	}
+
+	if err := f.Close(); err != nil {
+		_ = os.Remove(dest)
+		return "", "", fmt.Errorf("close archive: %v", err)
+	}
+
+	return dest, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeArchive writes the bagPath directory tree to w in the given format.
+func writeArchive(w io.Writer, bagPath, format string) error {
+	var (
+		aw  archiveWriter
+		gzw *gzip.Writer
+	)
+	switch format {
+	case "tar":
+		aw = &tarWriter{tar.NewWriter(w)}
+	case "tar.gz":
+		gzw = gzip.NewWriter(w)
+		aw = &tarWriter{tar.NewWriter(gzw)}
+	case "zip":
+		aw = &zipWriter{zip.NewWriter(w)}
+	default:
+		return fmt.Errorf("unsupported serialization format: %q", format)
+	}
+
+	root, err := os.OpenRoot(bagPath)
+	if err != nil {
+		return fmt.Errorf("open root: %v", err)
+	}
+	defer root.Close()
+
+	// Include the Bag directory name in the archive paths.
+	base := filepath.Base(bagPath)
+	err = fs.WalkDir(root.FS(), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		name := path.Join(base, p)
+
+		if d.IsDir() {
+			return aw.add(name, fi, nil)
+		}
+		if !fi.Mode().IsRegular() {
+			return fmt.Errorf("unsupported file type: %q", p)
+		}
+
+		r, err := root.Open(p)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		return aw.add(name, fi, r)
+	})
+	if err != nil {
+		return fmt.Errorf("add files: %v", err)
+	}
+
+	if err := aw.Close(); err != nil {
+		return fmt.Errorf("close archive writer: %v", err)
+	}
+	if gzw != nil {
+		if err := gzw.Close(); err != nil {
+			return fmt.Errorf("close gzip writer: %v", err)
+		}
+	}
+
+	return nil
+}
+
+type tarWriter struct {
+	*tar.Writer
+}
+
+func (w *tarWriter) add(name string, fi fs.FileInfo, r io.Reader) error {
+	hdr, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if fi.IsDir() {
+		hdr.Name += "/"
+	}
+
+	if err := w.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if r != nil {
+		if _, err := io.Copy(w, r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type zipWriter struct {
+	*zip.Writer
+}
+
+func (w *zipWriter) add(name string, fi fs.FileInfo, r io.Reader) error {
+	hdr, err := zip.FileInfoHeader(fi)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if fi.IsDir() {
+		hdr.Name += "/"
+	} else {
+		hdr.Method = zip.Deflate
+	}
+
+	fw, err := w.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	if r != nil {
+		if _, err := io.Copy(fw, r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
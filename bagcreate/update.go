@@ -0,0 +1,187 @@
+package bagcreate
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// changes lists the payload files added, removed or changed in a Bag update.
+type changes struct {
+	added   []string
+	removed []string
+	changed []string
+}
+
+// update updates the existing Bag at bagPath to match the current contents of
+// its data directory. It regenerates the payload manifests, the Payload-Oxum
+// in bag-info.txt and the tag manifests, keeping the checksum algorithms
+// already used by the Bag and the other bag-info.txt tags.
+func (a *Activity) update(bagPath string) (*changes, error) {
+	algs, err := manifestAlgorithms(bagPath, "manifest")
+	if err != nil {
+		return nil, fmt.Errorf("find manifests: %v", err)
+	}
+	if len(algs) == 0 {
+		algs = []string{a.cfg.ChecksumAlgorithm}
+	}
+
+	// Compare the payload against the manifest of the configured algorithm, or
+	// the first one found if the Bag doesn't use the configured algorithm.
+	cmpAlg := algs[0]
+	if slices.Contains(algs, a.cfg.ChecksumAlgorithm) {
+		cmpAlg = a.cfg.ChecksumAlgorithm
+	}
+	old, err := readManifest(filepath.Join(bagPath, manifestName("manifest", cmpAlg)))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read manifest: %v", err)
+	}
+
+	entries := make(map[string]map[string]string, len(algs))
+	for _, alg := range algs {
+		entries[alg] = make(map[string]string)
+	}
+
+	var (
+		size  int64
+		count int
+		c     changes
+	)
+	err = filepath.WalkDir(filepath.Join(bagPath, "data"), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += fi.Size()
+		count++
+
+		sums, err := fileChecksums(p, algs)
+		if err != nil {
+			return fmt.Errorf("generate checksums: %v", err)
+		}
+
+		rel, err := filepath.Rel(bagPath, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		for alg, sum := range sums {
+			entries[alg][rel] = sum
+		}
+
+		switch prev, ok := old[rel]; {
+		case !ok:
+			c.added = append(c.added, rel)
+		case prev != sums[cmpAlg]:
+			c.changed = append(c.changed, rel)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scan payload: %v", err)
+	}
+
+	for p := range old {
+		if _, ok := entries[cmpAlg][p]; !ok {
+			c.removed = append(c.removed, p)
+		}
+	}
+	slices.Sort(c.removed)
+
+	for _, alg := range algs {
+		if err := writeManifest(filepath.Join(bagPath, manifestName("manifest", alg)), entries[alg]); err != nil {
+			return nil, fmt.Errorf("write manifest: %v", err)
+		}
+	}
+
+	bagInfoPath := filepath.Join(bagPath, "bag-info.txt")
+	bagInfo, err := os.ReadFile(bagInfoPath) // #nosec G304 -- trusted path.
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read bag-info.txt: %v", err)
+	}
+	bagInfo = setTag(bagInfo, "Payload-Oxum", fmt.Sprintf("%d.%d", size, count))
+	if err := os.WriteFile(bagInfoPath, bagInfo, fileMode); err != nil {
+		return nil, fmt.Errorf("write bag-info.txt: %v", err)
+	}
+
+	if err := writeTagManifests(bagPath, algs); err != nil {
+		return nil, fmt.Errorf("write tag manifests: %v", err)
+	}
+
+	return &c, nil
+}
+
+// writeTagManifests regenerates the tag manifests of the Bag at bagPath, with
+// the checksums of every file outside the data directory except the tag
+// manifests themselves. It keeps the algorithms of the existing tag
+// manifests, using algs if there are none.
+func writeTagManifests(bagPath string, algs []string) error {
+	tagAlgs, err := manifestAlgorithms(bagPath, "tagmanifest")
+	if err != nil {
+		return err
+	}
+	if len(tagAlgs) == 0 {
+		tagAlgs = algs
+	}
+
+	entries := make(map[string]map[string]string, len(tagAlgs))
+	for _, alg := range tagAlgs {
+		entries[alg] = make(map[string]string)
+	}
+
+	err = filepath.WalkDir(bagPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(bagPath, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if rel == "data" {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if path.Dir(rel) == "." && strings.HasPrefix(rel, "tagmanifest-") {
+			return nil
+		}
+
+		sums, err := fileChecksums(p, tagAlgs)
+		if err != nil {
+			return err
+		}
+		for alg, sum := range sums {
+			entries[alg][rel] = sum
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, alg := range tagAlgs {
+		if err := writeManifest(filepath.Join(bagPath, manifestName("tagmanifest", alg)), entries[alg]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
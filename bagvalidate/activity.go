@@ -6,14 +6,32 @@ import (
 	"fmt"
 
 	"go.artefactual.dev/tools/temporal"
+
+	"github.com/artefactual-sdps/temporal-activities/internal/heartbeat"
 )
 
 const Name = "bag-validate"
 
 type (
 	Params struct {
-		// Path is the full path of the Bag to be validated.
+		// Path is the full path of the Bag to be validated, either a Bag
+		// directory or a Bag serialized as a tar or zip file.
 		Path string
+
+		// Mode is the validation mode: ModeFull (the default) validates the
+		// checksums of all the files, ModeComplete only checks that all the
+		// files listed in the manifests exist and the Payload-Oxum matches,
+		// and ModeOxum only checks the Payload-Oxum. Modes other than
+		// ModeFull require a validator implementing ModeValidator.
+		Mode Mode
+
+		// ProfilePath is the path of a BagIt Profile JSON file the Bag is
+		// validated against. It can't be set with Profile.
+		ProfilePath string
+
+		// Profile is a BagIt Profile JSON document the Bag is validated
+		// against. It can't be set with ProfilePath.
+		Profile string
 	}
 	Result struct {
 		// Valid is true if the Bag is valid.
@@ -22,19 +40,30 @@ type (
 		// Error is a message indicating why validation failed, and will always be
 		// empty when Valid is true.
 		Error string
+
+		// Findings lists the problems that made the Bag invalid, when the
+		// validator reports them. It will always be empty when Valid is true.
+		Findings []Finding
+
+		// ProfileViolations lists the BagIt Profile requirements not met by
+		// the Bag, when a profile is given. Profile violations don't change
+		// Valid, which only reports the Bag validation result.
+		ProfileViolations []ProfileViolation
 	}
 	Activity struct {
-		validator BagValidator
+		validator ContextValidator
 	}
 )
 
 // New creates a new bagvalidate activity.
 // If the provided validator is nil, it defaults to using gythonValidator.
+// Validators not implementing ContextValidator are adapted with
+// NewContextValidator.
 func New(validator BagValidator) *Activity {
 	if validator == nil {
 		validator = NewGythonValidator()
 	}
-	return &Activity{validator: validator}
+	return &Activity{validator: NewContextValidator(validator)}
 }
 
 // Execute validates the BagIt Bag located at Path.
@@ -42,18 +71,68 @@ func New(validator BagValidator) *Activity {
 // If validation succeeds Execute returns `&ValidateActivityResult{Valid: true},
 // nil`.
 // If validation fails Execute returns `&ValidateActivityResult{Valid: false,
-// Error: "message"}, nil`.
+// Error: "message", Findings: []Finding{...}}, nil`.
 // If an application error occurs Execute returns `nil, error("message")`
+//
+// The validation progress is recorded in the activity heartbeats and the
+// validation stops if the activity is cancelled.
 func (a *Activity) Execute(ctx context.Context, params *Params) (*Result, error) {
 	logger := temporal.GetLogger(ctx)
 	logger.V(1).Info("Executing bag-validate activity", "Path", params.Path)
 
-	if err := a.validator.Validate(params.Path); err != nil {
-		if errors.Is(convertError(err), ErrInvalid) {
-			return &Result{
+	if err := params.Mode.validate(); err != nil {
+		return nil, fmt.Errorf("bagvalidate: Mode: %v", err)
+	}
+
+	prof, err := loadProfile(params.ProfilePath, params.Profile)
+	if err != nil {
+		return nil, fmt.Errorf("bagvalidate: profile: %v", err)
+	}
+
+	// Keep heartbeating while the profile is validated, which reads the Bag
+	// again.
+	h := heartbeat.Start[Progress](ctx)
+	defer h.Stop()
+
+	res, err := a.validate(ctx, params, h.Set)
+	if err != nil {
+		return nil, err
+	}
+
+	if prof != nil {
+		res.ProfileViolations, err = prof.validate(ctx, params.Path)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bagvalidate: profile: %v", err)
+		}
+	}
+
+	return res, nil
+}
+
+// validate validates the Bag at params.Path with the configured validator,
+// reporting the progress to progress and returning an error if the
+// validation couldn't be completed.
+func (a *Activity) validate(ctx context.Context, params *Params, progress func(Progress)) (*Result, error) {
+	err := a.validator.ValidateContext(ctx, params.Path, params.Mode, progress)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		if cerr := convertError(err); errors.Is(cerr, ErrInvalid) {
+			res := &Result{
 				Valid: false,
 				Error: err.Error(),
-			}, nil
+			}
+
+			var verr *ValidationError
+			if errors.As(cerr, &verr) {
+				res.Findings = verr.Findings
+			}
+
+			return res, nil
 		}
 
 		return nil, fmt.Errorf("bagvalidate: %v", err)
@@ -2,11 +2,13 @@ package bagvalidate_test
 
 import (
 	"errors"
+	"fmt"
 	"io/fs"
 	"testing"
 
 	bagit_gython "github.com/artefactual-labs/bagit-gython"
 	temporalsdk_activity "go.temporal.io/sdk/activity"
+	temporalsdk_converter "go.temporal.io/sdk/converter"
 	temporalsdk_testsuite "go.temporal.io/sdk/testsuite"
 	"gotest.tools/v3/assert"
 	tfs "gotest.tools/v3/fs"
@@ -111,6 +113,14 @@ func TestActivity(t *testing.T) {
 			want: bagvalidate.Result{
 				Valid: false,
 				Error: "invalid: Payload-Oxum validation failed. Expected 2 files and 38 bytes but found 1 files and 19 bytes",
+				Findings: []bagvalidate.Finding{
+					{
+						Code:     bagvalidate.FindingOxumMismatch,
+						Expected: "38.2",
+						Actual:   "19.1",
+						Message:  "Payload-Oxum validation failed. Expected 2 files and 38 bytes but found 1 files and 19 bytes",
+					},
+				},
 			},
 		},
 	} {
@@ -135,6 +145,296 @@ func TestActivity(t *testing.T) {
 	}
 }
 
+func TestActivityFindings(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		name string
+		err  error
+		want bagvalidate.Result
+	}
+	for _, tt := range []test{
+		{
+			name: "Returns manifest findings",
+			err: fmt.Errorf(
+				"%w: %s",
+				bagit_gython.ErrInvalid,
+				`Bag validation failed: data/a.txt sha512 validation failed: expected="abc" found="def"; `+
+					`data/b.txt exists in manifest but was not found on filesystem; `+
+					`data/c.txt exists on filesystem but is not in the manifest`,
+			),
+			want: bagvalidate.Result{
+				Valid: false,
+				Error: `invalid: Bag validation failed: data/a.txt sha512 validation failed: expected="abc" found="def"; ` +
+					`data/b.txt exists in manifest but was not found on filesystem; ` +
+					`data/c.txt exists on filesystem but is not in the manifest`,
+				Findings: []bagvalidate.Finding{
+					{
+						Code:      bagvalidate.FindingChecksumMismatch,
+						Path:      "data/a.txt",
+						Algorithm: "sha512",
+						Expected:  "abc",
+						Actual:    "def",
+						Message:   `data/a.txt sha512 validation failed: expected="abc" found="def"`,
+					},
+					{
+						Code:    bagvalidate.FindingMissingFile,
+						Path:    "data/b.txt",
+						Message: "data/b.txt exists in manifest but was not found on filesystem",
+					},
+					{
+						Code:    bagvalidate.FindingExtraFile,
+						Path:    "data/c.txt",
+						Message: "data/c.txt exists on filesystem but is not in the manifest",
+					},
+				},
+			},
+		},
+		{
+			name: "Returns a bad tag file finding",
+			err:  fmt.Errorf("%w: %s", bagit_gython.ErrInvalid, "Expected bagit.txt does not exist: /tmp/bag/bagit.txt"),
+			want: bagvalidate.Result{
+				Valid: false,
+				Error: "invalid: Expected bagit.txt does not exist: /tmp/bag/bagit.txt",
+				Findings: []bagvalidate.Finding{
+					{
+						Code:    bagvalidate.FindingBadTagFile,
+						Message: "Expected bagit.txt does not exist: /tmp/bag/bagit.txt",
+					},
+				},
+			},
+		},
+		{
+			name: "Returns an invalid bag finding",
+			err:  fmt.Errorf("%w: %s", bagit_gython.ErrInvalid, "Expected data directory /tmp/bag/data does not exist"),
+			want: bagvalidate.Result{
+				Valid: false,
+				Error: "invalid: Expected data directory /tmp/bag/data does not exist",
+				Findings: []bagvalidate.Finding{
+					{
+						Code:    bagvalidate.FindingInvalidBag,
+						Message: "Expected data directory /tmp/bag/data does not exist",
+					},
+				},
+			},
+		},
+		{
+			name: "Returns no findings without details",
+			err:  bagvalidate.ErrInvalid,
+			want: bagvalidate.Result{
+				Valid: false,
+				Error: "invalid",
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			validator := bagvalidate.NewMockValidator().SetErr(tt.err)
+			ts := &temporalsdk_testsuite.WorkflowTestSuite{}
+			env := ts.NewTestActivityEnvironment()
+			env.RegisterActivityWithOptions(
+				bagvalidate.New(validator).Execute,
+				temporalsdk_activity.RegisterOptions{Name: bagvalidate.Name},
+			)
+
+			enc, err := env.ExecuteActivity(bagvalidate.Name, bagvalidate.Params{})
+			assert.NilError(t, err)
+
+			var result bagvalidate.Result
+			_ = enc.Get(&result)
+			assert.DeepEqual(t, result, tt.want)
+		})
+	}
+}
+
+func TestActivityProfile(t *testing.T) {
+	t.Parallel()
+
+	const conformingProfile = `{
+	"BagIt-Profile-Info": {"BagIt-Profile-Identifier": "https://example.com/profile.json"},
+	"Bag-Info": {
+		"Bagging-Date": {"required": true, "repeatable": false},
+		"Payload-Oxum": {"required": true}
+	},
+	"Manifests-Required": ["sha512"],
+	"Manifests-Allowed": ["sha256", "sha512"],
+	"Allow-Fetch.txt": false,
+	"Accept-BagIt-Version": ["0.97", "1.0"]
+}`
+
+	profilePath := tfs.NewFile(t, "profile.json", tfs.WithContent(conformingProfile)).Path()
+
+	type test struct {
+		name    string
+		params  bagvalidate.Params
+		want    bagvalidate.Result
+		wantErr string
+	}
+	for _, tt := range []test{
+		{
+			name:   "Validates a bag against a profile file",
+			params: bagvalidate.Params{Path: validTestBag(t), ProfilePath: profilePath},
+			want:   bagvalidate.Result{Valid: true},
+		},
+		{
+			name: "Returns profile violations",
+			params: bagvalidate.Params{
+				Path: validTestBag(t),
+				Profile: `{
+	"Bag-Info": {
+		"Source-Organization": {"required": true},
+		"Bag-Software-Agent": {"values": ["bagit-java"]},
+		"Contact-Name": {"required": false}
+	},
+	"Manifests-Required": ["md5"],
+	"Manifests-Allowed": ["md5"],
+	"Tag-Manifests-Allowed": ["md5"],
+	"Tag-Files-Required": ["bag-info.txt", "custom.txt"],
+	"Fetch.txt-Required": true,
+	"Accept-BagIt-Version": ["1.0"]
+}`,
+			},
+			want: bagvalidate.Result{
+				Valid: true,
+				ProfileViolations: []bagvalidate.ProfileViolation{
+					{
+						Rule:    "Accept-BagIt-Version",
+						Message: "BagIt version 0.97 is not accepted (1.0)",
+					},
+					{
+						Rule: "Bag-Info",
+						Message: `Tag Bag-Software-Agent has value ` +
+							`"bagvalidate.py v1.8.1 <https://github.com/LibraryOfCongress/bagit-python>", ` +
+							`must be one of (bagit-java)`,
+					},
+					{
+						Rule:    "Bag-Info",
+						Message: "Required tag Source-Organization is missing from bag-info.txt",
+					},
+					{
+						Rule:    "Manifests-Required",
+						Message: "Required manifest algorithm md5 is missing",
+					},
+					{
+						Rule:    "Manifests-Allowed",
+						Message: "Manifest algorithm sha512 is not allowed",
+					},
+					{
+						Rule:    "Tag-Manifests-Allowed",
+						Message: "Tag-Manifest algorithm sha512 is not allowed",
+					},
+					{
+						Rule:    "Tag-Files-Required",
+						Message: "Required tag file custom.txt is missing",
+					},
+					{
+						Rule:    "Fetch.txt-Required",
+						Message: "fetch.txt is required",
+					},
+				},
+			},
+		},
+		{
+			name: "Returns non repeatable tags and a disallowed fetch.txt",
+			params: bagvalidate.Params{
+				Path: tfs.NewDir(t, "temporal-activities-test",
+					tfs.WithFile("bag-info.txt", "Bagging-Date: 2024-07-04\nBagging-Date: 2024-07-05\nPayload-Oxum: 38.2\n"),
+					tfs.WithFile("bagit.txt", "BagIt-Version: 0.97\nTag-File-Character-Encoding: UTF-8\n"),
+					tfs.WithFile("fetch.txt", "https://example.com/another.txt 19 data/another.txt\n"),
+					tfs.WithFile("manifest-sha512.txt", sha512manifest),
+					tfs.WithDir("data",
+						tfs.WithFile("another.txt", "I am another file.\n"),
+						tfs.WithFile("small.txt", "I am a small file.\n"),
+					),
+				).Path(),
+				Profile: conformingProfile,
+			},
+			want: bagvalidate.Result{
+				Valid: true,
+				ProfileViolations: []bagvalidate.ProfileViolation{
+					{Rule: "Bag-Info", Message: "Tag Bagging-Date is not repeatable"},
+					{Rule: "Allow-Fetch.txt", Message: "fetch.txt is not allowed"},
+				},
+			},
+		},
+		{
+			name:   "Reports profile violations separately from validation errors",
+			params: bagvalidate.Params{Path: invalidTestBag(t), Profile: `{"Manifests-Required": ["md5"]}`},
+			want: bagvalidate.Result{
+				Valid: false,
+				Error: "invalid: Payload-Oxum validation failed. Expected 2 files and 38 bytes but found 1 files and 19 bytes",
+				Findings: []bagvalidate.Finding{
+					{
+						Code:     bagvalidate.FindingOxumMismatch,
+						Expected: "38.2",
+						Actual:   "19.1",
+						Message:  "Payload-Oxum validation failed. Expected 2 files and 38 bytes but found 1 files and 19 bytes",
+					},
+				},
+				ProfileViolations: []bagvalidate.ProfileViolation{
+					{Rule: "Manifests-Required", Message: "Required manifest algorithm md5 is missing"},
+				},
+			},
+		},
+		{
+			name:    "Errors if both profile parameters are set",
+			params:  bagvalidate.Params{Path: validTestBag(t), ProfilePath: profilePath, Profile: conformingProfile},
+			wantErr: "bagvalidate: profile: only one of ProfilePath or Profile can be set",
+		},
+		{
+			name:    "Errors if the profile is not valid JSON",
+			params:  bagvalidate.Params{Path: validTestBag(t), Profile: "{"},
+			wantErr: "bagvalidate: profile: decode profile: unexpected end of JSON input",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := &temporalsdk_testsuite.WorkflowTestSuite{}
+			env := ts.NewTestActivityEnvironment()
+			env.RegisterActivityWithOptions(
+				bagvalidate.New(bagvalidate.NewNativeValidator()).Execute,
+				temporalsdk_activity.RegisterOptions{Name: bagvalidate.Name},
+			)
+
+			enc, err := env.ExecuteActivity(bagvalidate.Name, tt.params)
+			if tt.wantErr != "" {
+				assert.Error(
+					t,
+					err,
+					"activity error (type: bag-validate, scheduledEventID: 0, startedEventID: 0, identity: ): "+tt.wantErr,
+				)
+				return
+			}
+			assert.NilError(t, err)
+
+			var result bagvalidate.Result
+			_ = enc.Get(&result)
+			assert.DeepEqual(t, result, tt.want)
+		})
+	}
+}
+
+func TestActivityHeartbeat(t *testing.T) {
+	t.Parallel()
+
+	var got bagvalidate.Progress
+	ts := &temporalsdk_testsuite.WorkflowTestSuite{}
+	env := ts.NewTestActivityEnvironment()
+	env.SetOnActivityHeartbeatListener(func(_ *temporalsdk_activity.Info, details temporalsdk_converter.EncodedValues) {
+		_ = details.Get(&got)
+	})
+	env.RegisterActivityWithOptions(
+		bagvalidate.New(bagvalidate.NewNativeValidator()).Execute,
+		temporalsdk_activity.RegisterOptions{Name: bagvalidate.Name},
+	)
+
+	_, err := env.ExecuteActivity(bagvalidate.Name, bagvalidate.Params{Path: validTestBag(t)})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, bagvalidate.Progress{Files: 2, Bytes: 38})
+}
+
 func TestActivitySystemError(t *testing.T) {
 	t.Parallel()
 
@@ -153,3 +453,150 @@ func TestActivitySystemError(t *testing.T) {
 		"activity error (type: bag-validate, scheduledEventID: 0, startedEventID: 0, identity: ): bagvalidate: transporter accident",
 	)
 }
+
+// fullValidator is a BagValidator that doesn't implement ModeValidator.
+type fullValidator struct{}
+
+func (fullValidator) Validate(string) error { return nil }
+
+func TestActivityModes(t *testing.T) {
+	t.Parallel()
+
+	const changedSHA512 = "b85e916f84e8c7df73eef54353f01b1daa0b814874d446b2657d2971872b5d312e9715538bd083ad95b6298ff43a4d497331732443b188efbaeaba76f520a0a7"
+	checksumErr := fmt.Sprintf(
+		`data/small.txt sha512 validation failed: expected="%s" found="%s"`,
+		smallSHA512,
+		changedSHA512,
+	)
+
+	changedTestBag := func(t *testing.T) string {
+		return tfs.NewDir(t, "temporal-activities-test",
+			tfs.WithFile("bag-info.txt", "Payload-Oxum: 38.2\n"),
+			tfs.WithFile("bagit.txt", "BagIt-Version: 0.97\nTag-File-Character-Encoding: UTF-8\n"),
+			tfs.WithFile("manifest-sha512.txt", sha512manifest),
+			tfs.WithDir("data",
+				tfs.WithFile("another.txt", "I am another file.\n"),
+				tfs.WithFile("small.txt", "I am a SMALL file.\n"),
+			),
+		).Path()
+	}
+
+	type test struct {
+		name      string
+		validator bagvalidate.BagValidator
+		params    bagvalidate.Params
+		want      bagvalidate.Result
+		wantErr   string
+	}
+	for _, tt := range []test{
+		{
+			name:      "Validates checksums in full mode",
+			validator: bagvalidate.NewNativeValidator(),
+			params:    bagvalidate.Params{Path: changedTestBag(t), Mode: bagvalidate.ModeFull},
+			want: bagvalidate.Result{
+				Valid: false,
+				Error: "invalid: Bag validation failed: " + checksumErr,
+				Findings: []bagvalidate.Finding{
+					{
+						Code:      bagvalidate.FindingChecksumMismatch,
+						Path:      "data/small.txt",
+						Algorithm: "sha512",
+						Expected:  smallSHA512,
+						Actual:    changedSHA512,
+						Message:   checksumErr,
+					},
+				},
+			},
+		},
+		{
+			name:      "Skips checksums in complete mode",
+			validator: bagvalidate.NewNativeValidator(),
+			params:    bagvalidate.Params{Path: changedTestBag(t), Mode: bagvalidate.ModeComplete},
+			want:      bagvalidate.Result{Valid: true},
+		},
+		{
+			name:      "Checks the Payload-Oxum in complete mode",
+			validator: bagvalidate.NewGythonValidator(),
+			params:    bagvalidate.Params{Path: invalidTestBag(t), Mode: bagvalidate.ModeComplete},
+			want: bagvalidate.Result{
+				Valid: false,
+				Error: "invalid: Payload-Oxum validation failed. Expected 2 files and 38 bytes but found 1 files and 19 bytes",
+				Findings: []bagvalidate.Finding{
+					{
+						Code:     bagvalidate.FindingOxumMismatch,
+						Expected: "38.2",
+						Actual:   "19.1",
+						Message:  "Payload-Oxum validation failed. Expected 2 files and 38 bytes but found 1 files and 19 bytes",
+					},
+				},
+			},
+		},
+		{
+			name:      "Only checks the Payload-Oxum in oxum mode",
+			validator: bagvalidate.NewGythonValidator(),
+			params:    bagvalidate.Params{Path: changedTestBag(t), Mode: bagvalidate.ModeOxum},
+			want:      bagvalidate.Result{Valid: true},
+		},
+		{
+			name:      "Requires a Payload-Oxum in oxum mode",
+			validator: bagvalidate.NewNativeValidator(),
+			params: bagvalidate.Params{
+				Path: tfs.NewDir(t, "temporal-activities-test",
+					tfs.WithFile("bagit.txt", "BagIt-Version: 0.97\nTag-File-Character-Encoding: UTF-8\n"),
+					tfs.WithFile("manifest-sha512.txt", sha512manifest),
+					tfs.WithDir("data"),
+				).Path(),
+				Mode: bagvalidate.ModeOxum,
+			},
+			want: bagvalidate.Result{
+				Valid: false,
+				Error: "invalid: Fast validation requires bag-info.txt to include Payload-Oxum",
+				Findings: []bagvalidate.Finding{
+					{
+						Code:    bagvalidate.FindingBadTagFile,
+						Path:    "bag-info.txt",
+						Message: "Fast validation requires bag-info.txt to include Payload-Oxum",
+					},
+				},
+			},
+		},
+		{
+			name:      "Errors if the validator doesn't support the mode",
+			validator: fullValidator{},
+			params:    bagvalidate.Params{Path: validTestBag(t), Mode: bagvalidate.ModeComplete},
+			wantErr:   `bagvalidate: validator doesn't support "complete" mode`,
+		},
+		{
+			name:      "Errors if the mode is invalid",
+			validator: bagvalidate.NewNativeValidator(),
+			params:    bagvalidate.Params{Path: validTestBag(t), Mode: "quick"},
+			wantErr:   `bagvalidate: Mode: invalid value "quick", must be one of (full, complete, oxum)`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := &temporalsdk_testsuite.WorkflowTestSuite{}
+			env := ts.NewTestActivityEnvironment()
+			env.RegisterActivityWithOptions(
+				bagvalidate.New(tt.validator).Execute,
+				temporalsdk_activity.RegisterOptions{Name: bagvalidate.Name},
+			)
+
+			enc, err := env.ExecuteActivity(bagvalidate.Name, tt.params)
+			if tt.wantErr != "" {
+				assert.Error(
+					t,
+					err,
+					"activity error (type: bag-validate, scheduledEventID: 0, startedEventID: 0, identity: ): "+tt.wantErr,
+				)
+				return
+			}
+			assert.NilError(t, err)
+
+			var result bagvalidate.Result
+			_ = enc.Get(&result)
+			assert.DeepEqual(t, result, tt.want)
+		})
+	}
+}
@@ -0,0 +1,447 @@
+package bagvalidate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// archiveFormats lists the file extensions of the supported serialized Bags.
+var archiveFormats = []string{".tar", ".tar.gz", ".tgz", ".zip"}
+
+// checksumAlgorithms lists the checksum algorithms supported by newHash.
+var checksumAlgorithms = []string{"md5", "sha1", "sha224", "sha256", "sha384", "sha512"}
+
+// isArchive returns true if path is a regular file, i.e. a serialized Bag.
+func isArchive(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// archiveFormat returns the archive format of the file at path, one of
+// archiveFormats, or its extension if the format isn't supported.
+func archiveFormat(path string) string {
+	name := strings.ToLower(path)
+	for _, f := range archiveFormats {
+		if strings.HasSuffix(name, f) {
+			return f
+		}
+	}
+
+	return filepath.Ext(name)
+}
+
+// openFS returns a file system with the contents of the Bag at path, which
+// can be a directory or a serialized Bag. If a serialized Bag has a single
+// top-level directory, it's used as the root of the file system. Tar archives
+// are read in a single pass, hashing their files if hash is true, see tarFS.
+func openFS(ctx context.Context, path string, hash bool, t *tracker) (fs.FS, io.Closer, error) {
+	if !isArchive(path) {
+		root, err := os.OpenRoot(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return root.FS(), root, nil
+	}
+
+	var (
+		fsys   fs.FS
+		closer io.Closer
+	)
+	switch format := archiveFormat(path); format {
+	case ".zip":
+		r, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read zip: %v", err)
+		}
+		fsys, closer = r, r
+	case ".tar", ".tar.gz", ".tgz":
+		tf, err := loadTar(ctx, path, format != ".tar", hash, t)
+		if err != nil {
+			return nil, nil, err
+		}
+		return tf, tf, nil
+	default:
+		return nil, nil, fmt.Errorf(
+			"unsupported archive format %q, must be one of (%s)",
+			format, strings.Join(archiveFormats, ", "),
+		)
+	}
+
+	if _, err := fs.Stat(fsys, "bagit.txt"); err == nil {
+		return fsys, closer, nil
+	}
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		_ = closer.Close()
+		return nil, nil, fmt.Errorf("read archive: %v", err)
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		sub, err := fs.Sub(fsys, entries[0].Name())
+		if err != nil {
+			_ = closer.Close()
+			return nil, nil, fmt.Errorf("read archive: %v", err)
+		}
+		fsys = sub
+	}
+
+	return fsys, closer, nil
+}
+
+// tarFS is a read-only fs.FS of the regular files and directories in a tar
+// archive, loaded in a single pass over the archive stream so it also works
+// with compressed archives. The contents of the tag files needed to load the
+// Bag are kept in memory, the other files are hashed while the archive is
+// read, and their contents can't be read afterwards. The files are hashed with
+// the algorithms of the manifests read before them, or with all the checksum
+// algorithms if the manifests come after them in the archive.
+type tarFS struct {
+	entries map[string]*tarEntry
+}
+
+// tarEntry is a file or directory in a tarFS.
+type tarEntry struct {
+	info fs.FileInfo
+
+	// data is the content of a file kept in memory.
+	data []byte
+
+	// sums maps the checksum algorithms to the checksums of a hashed file.
+	sums map[string]string
+
+	// children lists the names of the entries in a directory.
+	children []string
+}
+
+// loadTar reads the tar archive file, gzip compressed if gz is true, and
+// returns a tarFS with its entries. The files that are not kept in memory are
+// hashed if hash is true, tracking the bytes read with t. The archive is only
+// read again to hash the files read before a manifest with an algorithm they
+// were not hashed with.
+func loadTar(ctx context.Context, file string, gz, hash bool, t *tracker) (*tarFS, error) {
+	tf := &tarFS{entries: map[string]*tarEntry{".": {info: dirInfo(".")}}}
+
+	// algs lists the algorithms of the manifests read so far.
+	var algs []string
+	err := readTar(ctx, file, gz, func(hdr *tar.Header, name string, r io.Reader) error {
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			tf.dir(name)
+		case tar.TypeReg:
+			var (
+				e   = &tarEntry{info: hdr.FileInfo()}
+				err error
+			)
+			switch {
+			case isTagFile(name):
+				e.data, err = io.ReadAll(r)
+				if alg, ok := tagFileAlgorithm(name); ok && !slices.Contains(algs, alg) {
+					algs = append(algs, alg)
+				}
+			case hash && len(algs) == 0:
+				e.sums, err = hashReader(ctx, r, checksumAlgorithms, t)
+			case hash:
+				e.sums, err = hashReader(ctx, r, algs, t)
+			default:
+				_, err = io.Copy(io.Discard, &ctxReader{ctx: ctx, r: r})
+			}
+			if err != nil {
+				return err
+			}
+			tf.file(name, e)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if hash {
+		if err := tf.rehash(ctx, file, gz, algs, t); err != nil {
+			return nil, err
+		}
+	}
+
+	return tf.root(), nil
+}
+
+// readTar reads the tar archive file, gzip compressed if gz is true, calling
+// fn with the header, the clean name and the content of each entry. Entries
+// with invalid names are skipped.
+func readTar(ctx context.Context, file string, gz bool, fn func(*tar.Header, string, io.Reader) error) error {
+	f, err := os.Open(file) // #nosec G304 -- trusted file path.
+	if err != nil {
+		return fmt.Errorf("read tar: %v", err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if gz {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("read gzip: %v", err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read tar: %v", err)
+		}
+
+		name := path.Clean(hdr.Name)
+		if !fs.ValidPath(name) || name == "." {
+			continue
+		}
+		if err := fn(hdr, name, tr); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("read tar: %v", err)
+		}
+	}
+}
+
+// hashReader returns the checksums of the content of r for each of the given
+// algorithms, tracking the bytes read with t.
+func hashReader(ctx context.Context, r io.Reader, algs []string, t *tracker) (map[string]string, error) {
+	mh := newMultiHash(algs)
+	if _, err := io.Copy(io.MultiWriter(mh, t), &ctxReader{ctx: ctx, r: r}); err != nil {
+		return nil, err
+	}
+
+	return mh.sums(), nil
+}
+
+// rehash reads the tar archive file again to hash the files missing the
+// checksums of any of the given algorithms, because they were read before the
+// manifest using it.
+func (t *tarFS) rehash(ctx context.Context, file string, gz bool, algs []string, tr *tracker) error {
+	missing := make(map[string][]string)
+	for name, e := range t.entries {
+		if e.sums == nil {
+			continue
+		}
+		for _, alg := range algs {
+			if _, ok := e.sums[alg]; !ok {
+				missing[name] = append(missing[name], alg)
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	return readTar(ctx, file, gz, func(hdr *tar.Header, name string, r io.Reader) error {
+		if hdr.Typeflag != tar.TypeReg || missing[name] == nil {
+			return nil
+		}
+		sums, err := hashReader(ctx, r, missing[name], tr)
+		if err != nil {
+			return err
+		}
+		maps.Copy(t.entries[name].sums, sums)
+
+		return nil
+	})
+}
+
+// isTagFile returns true if name is a tag file read to load a Bag, at the root
+// of the archive or in a top-level directory.
+func isTagFile(name string) bool {
+	if dir := path.Dir(name); dir != "." && strings.Contains(dir, "/") {
+		return false
+	}
+
+	base := path.Base(name)
+	if base == "bagit.txt" || base == "bag-info.txt" || base == "fetch.txt" {
+		return true
+	}
+	if _, ok := manifestAlgorithm("manifest", base); ok {
+		return true
+	}
+	_, ok := manifestAlgorithm("tagmanifest", base)
+
+	return ok
+}
+
+// tagFileAlgorithm returns the checksum algorithm of the manifest or tag
+// manifest tag file name, and false if it isn't a manifest or the algorithm
+// isn't supported.
+func tagFileAlgorithm(name string) (string, bool) {
+	base := path.Base(name)
+	alg, ok := manifestAlgorithm("manifest", base)
+	if !ok {
+		alg, ok = manifestAlgorithm("tagmanifest", base)
+	}
+
+	return alg, ok && slices.Contains(checksumAlgorithms, alg)
+}
+
+// root returns the tarFS rooted at its single top-level directory, if it
+// doesn't have a bagit.txt file at the root, or t otherwise.
+func (t *tarFS) root() *tarFS {
+	if _, ok := t.entries["bagit.txt"]; ok {
+		return t
+	}
+	children := t.entries["."].children
+	if len(children) != 1 || !t.entries[children[0]].info.IsDir() {
+		return t
+	}
+
+	prefix := children[0] + "/"
+	sub := &tarFS{entries: map[string]*tarEntry{".": t.entries[children[0]]}}
+	for name, e := range t.entries {
+		if rel, ok := strings.CutPrefix(name, prefix); ok {
+			for i, c := range e.children {
+				e.children[i] = strings.TrimPrefix(c, prefix)
+			}
+			sub.entries[rel] = e
+		}
+	}
+	for i, c := range sub.entries["."].children {
+		sub.entries["."].children[i] = strings.TrimPrefix(c, prefix)
+	}
+
+	return sub
+}
+
+// dir returns the directory entry name, adding it and its parents if they
+// don't exist.
+func (t *tarFS) dir(name string) *tarEntry {
+	if e, ok := t.entries[name]; ok {
+		return e
+	}
+
+	e := &tarEntry{info: dirInfo(name)}
+	t.entries[name] = e
+	parent := t.dir(path.Dir(name))
+	parent.children = append(parent.children, name)
+
+	return e
+}
+
+// file adds the file entry name, replacing any previous entry with the same
+// name.
+func (t *tarFS) file(name string, e *tarEntry) {
+	if _, ok := t.entries[name]; !ok {
+		parent := t.dir(path.Dir(name))
+		parent.children = append(parent.children, name)
+	}
+	t.entries[name] = e
+}
+
+// checksums returns the checksums of the file name generated while the
+// archive was read, and false if the file wasn't hashed.
+func (t *tarFS) checksums(name string) (map[string]string, bool) {
+	e, ok := t.entries[name]
+	if !ok || e.sums == nil {
+		return nil, false
+	}
+
+	return e.sums, true
+}
+
+func (t *tarFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	e, ok := t.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return &tarFile{fs: t, entry: e, r: bytes.NewReader(e.data)}, nil
+}
+
+func (t *tarFS) Close() error {
+	return nil
+}
+
+// tarFile is an open file or directory in a tarFS.
+type tarFile struct {
+	fs    *tarFS
+	entry *tarEntry
+	r     *bytes.Reader
+
+	// read is the number of directory entries already read.
+	read int
+}
+
+func (f *tarFile) Stat() (fs.FileInfo, error) {
+	return f.entry.info, nil
+}
+
+func (f *tarFile) Read(p []byte) (int, error) {
+	if f.entry.info.IsDir() {
+		return 0, &fs.PathError{Op: "read", Path: f.entry.info.Name(), Err: errors.New("is a directory")}
+	}
+	if f.entry.data == nil && f.entry.info.Size() > 0 {
+		return 0, &fs.PathError{
+			Op:   "read",
+			Path: f.entry.info.Name(),
+			Err:  errors.New("file content was not kept in memory"),
+		}
+	}
+	return f.r.Read(p)
+}
+
+func (f *tarFile) Close() error {
+	return nil
+}
+
+func (f *tarFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !f.entry.info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: f.entry.info.Name(), Err: errors.New("not a directory")}
+	}
+
+	names := slices.Sorted(slices.Values(f.entry.children))[f.read:]
+	if n > 0 && len(names) > n {
+		names = names[:n]
+	}
+	if n > 0 && len(names) == 0 {
+		return nil, io.EOF
+	}
+
+	entries := make([]fs.DirEntry, len(names))
+	for i, name := range names {
+		entries[i] = fs.FileInfoToDirEntry(f.fs.entries[name].info)
+	}
+	f.read += len(names)
+
+	return entries, nil
+}
+
+// dirInfo is the fs.FileInfo of a tarFS directory.
+type dirInfo string
+
+func (d dirInfo) Name() string       { return path.Base(string(d)) }
+func (d dirInfo) Size() int64        { return 0 }
+func (d dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0o555 }
+func (d dirInfo) ModTime() time.Time { return time.Time{} }
+func (d dirInfo) IsDir() bool        { return true }
+func (d dirInfo) Sys() any           { return nil }
@@ -3,6 +3,7 @@ package bagvalidate
 import (
 	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 
 	bagit_gython "github.com/artefactual-labs/bagit-gython"
@@ -10,11 +11,95 @@ import (
 
 var ErrInvalid = errors.New("invalid")
 
+// ValidationError is an ErrInvalid error including the findings that made the
+// Bag invalid.
+type ValidationError struct {
+	// Message summarizes the validation failure.
+	Message string
+
+	// Findings lists the problems found in the Bag.
+	Findings []Finding
+}
+
+var _ error = (*ValidationError)(nil)
+
+func (e *ValidationError) Error() string {
+	if e.Message == "" {
+		return ErrInvalid.Error()
+	}
+	return fmt.Sprintf("%v: %s", ErrInvalid, e.Message)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalid
+}
+
+var (
+	oxumRegex     = regexp.MustCompile(`^Payload-Oxum validation failed\. Expected (\d+) files and (\d+) bytes but found (\d+) files and (\d+) bytes$`)
+	checksumRegex = regexp.MustCompile(`^(.+) (\w+) validation failed: expected="(.*)" found="(.*)"$`)
+	missingRegex  = regexp.MustCompile(`^(.+) exists in manifest but was not found on filesystem$`)
+	extraRegex    = regexp.MustCompile(`^(.+) exists on filesystem but is not in the manifest$`)
+	tagFileRegex  = regexp.MustCompile(`(?i)(bagit\.txt|bag-info\.txt|manifest|tag|bag version|encoding)`)
+)
+
 func convertError(err error) error {
 	if errors.Is(err, bagit_gython.ErrInvalid) {
 		msg, _ := strings.CutPrefix(err.Error(), "invalid: ")
-		err = fmt.Errorf("%w: %s", ErrInvalid, msg)
+		err = &ValidationError{Message: msg, Findings: parseFindings(msg)}
 	}
 
 	return err
 }
+
+// parseFindings parses a bagit-python validation error message into findings.
+func parseFindings(msg string) []Finding {
+	if msg == "" || msg == ErrInvalid.Error() {
+		return nil
+	}
+
+	if m := oxumRegex.FindStringSubmatch(msg); m != nil {
+		return []Finding{{
+			Code:     FindingOxumMismatch,
+			Expected: m[2] + "." + m[1],
+			Actual:   m[4] + "." + m[3],
+			Message:  msg,
+		}}
+	}
+
+	details, ok := strings.CutPrefix(msg, "Bag validation failed: ")
+	if !ok {
+		return []Finding{parseFinding(msg)}
+	}
+
+	var findings []Finding
+	for d := range strings.SplitSeq(details, "; ") {
+		findings = append(findings, parseFinding(d))
+	}
+
+	return findings
+}
+
+// parseFinding parses a single bagit-python validation error detail.
+func parseFinding(d string) Finding {
+	if m := checksumRegex.FindStringSubmatch(d); m != nil {
+		return Finding{
+			Code:      FindingChecksumMismatch,
+			Path:      m[1],
+			Algorithm: m[2],
+			Expected:  m[3],
+			Actual:    m[4],
+			Message:   d,
+		}
+	}
+	if m := missingRegex.FindStringSubmatch(d); m != nil {
+		return Finding{Code: FindingMissingFile, Path: m[1], Message: d}
+	}
+	if m := extraRegex.FindStringSubmatch(d); m != nil {
+		return Finding{Code: FindingExtraFile, Path: m[1], Message: d}
+	}
+	if tagFileRegex.MatchString(d) {
+		return Finding{Code: FindingBadTagFile, Message: d}
+	}
+
+	return Finding{Code: FindingInvalidBag, Message: d}
+}
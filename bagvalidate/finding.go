@@ -0,0 +1,53 @@
+package bagvalidate
+
+// A FindingCode identifies the kind of problem described by a Finding. Codes
+// are stable and can be used to group or translate findings.
+type FindingCode string
+
+const (
+	// FindingMissingFile is a file listed in a manifest that is missing from
+	// the Bag.
+	FindingMissingFile FindingCode = "missing_file"
+
+	// FindingExtraFile is a payload file that is not listed in one or more of
+	// the payload manifests.
+	FindingExtraFile FindingCode = "extra_file"
+
+	// FindingChecksumMismatch is a file whose checksum doesn't match the one
+	// in the manifest.
+	FindingChecksumMismatch FindingCode = "checksum_mismatch"
+
+	// FindingBadTagFile is a missing or malformed tag file (e.g. bagit.txt,
+	// bag-info.txt or a manifest).
+	FindingBadTagFile FindingCode = "bad_tag_file"
+
+	// FindingOxumMismatch is a Payload-Oxum that doesn't match the payload
+	// files.
+	FindingOxumMismatch FindingCode = "oxum_mismatch"
+
+	// FindingInvalidBag is any other problem making the Bag invalid, like a
+	// missing data directory.
+	FindingInvalidBag FindingCode = "invalid_bag"
+)
+
+// A Finding is a problem found during the validation of a Bag.
+type Finding struct {
+	// Code identifies the kind of problem.
+	Code FindingCode
+
+	// Path is the path of the affected file, relative to the Bag root (e.g.
+	// "data/file.txt"). It's empty if the problem isn't related to a file.
+	Path string
+
+	// Algorithm is the checksum algorithm for FindingChecksumMismatch.
+	Algorithm string
+
+	// Expected and Actual are the expected and found checksums for
+	// FindingChecksumMismatch and the expected and found Payload-Oxum values
+	// ("<bytes>.<files>") for FindingOxumMismatch.
+	Expected string
+	Actual   string
+
+	// Message is a human readable description of the problem.
+	Message string
+}
@@ -1,6 +1,7 @@
 package bagvalidate
 
 import (
+	"context"
 	"fmt"
 
 	bagit_gython "github.com/artefactual-labs/bagit-gython"
@@ -16,8 +17,13 @@ func NewGythonValidator() gythonValidator {
 }
 
 // Validate creates a new bagit_gython.BagIt validator, runs validation
-// on the given path, and calls Cleanup before returning.
+// on the given path, and calls Cleanup before returning. bagit-gython only
+// validates directories, so the native validator is used for serialized Bags.
 func (gv gythonValidator) Validate(path string) error {
+	if isArchive(path) {
+		return NewNativeValidator().Validate(path)
+	}
+
 	v, err := bagit_gython.NewBagIt()
 	if err != nil {
 		return fmt.Errorf("failed to create gython validator: %v", err)
@@ -31,4 +37,37 @@ func (gv gythonValidator) Validate(path string) error {
 	return v.Validate(path)
 }
 
-var _ BagValidator = gythonValidator{}
+// ValidateMode validates the Bag at path using the given mode. bagit-gython
+// only supports full validation of directories, so the native validator is
+// used for the other modes and for serialized Bags.
+func (gv gythonValidator) ValidateMode(path string, mode Mode) error {
+	if mode.String() != string(ModeFull) || isArchive(path) {
+		return NewNativeValidator().ValidateMode(path, mode)
+	}
+
+	return gv.Validate(path)
+}
+
+// ValidateContext validates the Bag at path using the given mode, and stops
+// waiting for the validation when ctx is cancelled. bagit-gython can't be
+// interrupted, so its Python subprocess keeps running until the validation
+// finishes and is cleaned up then. The native validator is used for modes
+// other than ModeFull and for serialized Bags, reporting the progress to
+// progress and stopping as soon as ctx is cancelled.
+func (gv gythonValidator) ValidateContext(
+	ctx context.Context,
+	path string,
+	mode Mode,
+	progress func(Progress),
+) error {
+	if mode.String() != string(ModeFull) || isArchive(path) {
+		return NewNativeValidator().ValidateContext(ctx, path, mode, progress)
+	}
+
+	return contextAdapter{v: gv}.ValidateContext(ctx, path, mode, progress)
+}
+
+var (
+	_ ModeValidator    = gythonValidator{}
+	_ ContextValidator = gythonValidator{}
+)
@@ -12,9 +12,13 @@ func (m mockValidator) Validate(_ string) error {
 	return m.err
 }
 
+func (m mockValidator) ValidateMode(_ string, _ Mode) error {
+	return m.err
+}
+
 func (m *mockValidator) SetErr(e error) *mockValidator {
 	m.err = e
 	return m
 }
 
-var _ BagValidator = mockValidator{}
+var _ ModeValidator = mockValidator{}
@@ -0,0 +1,588 @@
+package bagvalidate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// supportedVersions lists the BagIt versions supported by nativeValidator.
+var supportedVersions = []string{"0.97", "1.0"}
+
+// nativeValidator is a BagValidator implemented in Go that checks the Bag
+// structure, manifests, tag manifests, Payload-Oxum and fetch.txt of BagIt
+// 0.97 and 1.0 Bags. It validates Bag directories and Bags serialized as tar,
+// gzip compressed tar or zip files, reading the archive entries in place
+// without extracting them.
+// It doesn't keep any state between validations, so it's safe for concurrent
+// use.
+type nativeValidator struct{}
+
+// NewNativeValidator creates a new instance of nativeValidator.
+func NewNativeValidator() nativeValidator {
+	return nativeValidator{}
+}
+
+// Validate validates the Bag at path. If the Bag is invalid, a
+// *ValidationError is returned with the problems found, any other error
+// indicates that the validation couldn't be completed.
+func (v nativeValidator) Validate(path string) error {
+	return v.ValidateMode(path, ModeFull)
+}
+
+// ValidateMode validates the Bag at path using the given mode.
+func (v nativeValidator) ValidateMode(path string, mode Mode) error {
+	return v.ValidateContext(context.Background(), path, mode, nil)
+}
+
+// ValidateContext validates the Bag at path using the given mode, reporting
+// the files verified and bytes hashed to progress, if not nil. It stops and
+// returns ctx.Err() if ctx is cancelled.
+func (v nativeValidator) ValidateContext(
+	ctx context.Context,
+	path string,
+	mode Mode,
+	progress func(Progress),
+) error {
+	if err := mode.validate(); err != nil {
+		return fmt.Errorf("mode: %v", err)
+	}
+
+	t := &tracker{report: progress}
+	b, err := openBag(ctx, path, mode.String() == string(ModeFull), t)
+	if err != nil {
+		return err
+	}
+	defer b.close()
+
+	return b.validate(ctx, mode, t)
+}
+
+var (
+	_ ModeValidator    = nativeValidator{}
+	_ ContextValidator = nativeValidator{}
+)
+
+// fetchEntry is a fetch.txt line.
+type fetchEntry struct {
+	url    string
+	length string
+	path   string
+}
+
+// bag is a Bag loaded for validation, from a directory or a serialized Bag.
+type bag struct {
+	path   string
+	fsys   fs.FS
+	closer io.Closer
+
+	version      string
+	info         tags
+	manifests    []*manifest
+	tagManifests []*manifest
+	fetch        []fetchEntry
+
+	// payload maps the Bag relative paths of the payload files to their size.
+	payload map[string]int64
+}
+
+// invalid returns a *ValidationError with a single finding.
+func invalid(code FindingCode, path, msg string) *ValidationError {
+	return &ValidationError{
+		Message:  msg,
+		Findings: []Finding{{Code: code, Path: path, Message: msg}},
+	}
+}
+
+// openBag loads the tag files and payload file list of the Bag at path, which
+// can be a directory or a serialized Bag. The payload files are not read,
+// except for tar archives, which are read once and have their files hashed if
+// hash is true, tracking the bytes read with t.
+func openBag(ctx context.Context, path string, hash bool, t *tracker) (*bag, error) {
+	b := &bag{path: path, payload: make(map[string]int64)}
+
+	fsys, closer, err := openFS(ctx, path, hash, t)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, invalid(
+				FindingBadTagFile, "bagit.txt",
+				fmt.Sprintf("Expected bagit.txt does not exist: %s", filepath.Join(path, "bagit.txt")),
+			)
+		}
+		return nil, fmt.Errorf("open bag: %v", err)
+	}
+	b.fsys, b.closer = fsys, closer
+
+	if err := b.load(); err != nil {
+		b.close()
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (b *bag) close() {
+	_ = b.closer.Close()
+}
+
+// readTagFile returns the content of the tag file name, or nil and no error
+// if it doesn't exist.
+func (b *bag) readTagFile(name string) ([]byte, error) {
+	blob, err := fs.ReadFile(b.fsys, name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read %s: %v", name, err)
+	}
+
+	return blob, nil
+}
+
+func (b *bag) load() error {
+	blob, err := b.readTagFile("bagit.txt")
+	if err != nil {
+		return err
+	}
+	if blob == nil {
+		return invalid(
+			FindingBadTagFile, "bagit.txt",
+			fmt.Sprintf("Expected bagit.txt does not exist: %s", filepath.Join(b.path, "bagit.txt")),
+		)
+	}
+
+	declaration, err := parseTags(blob)
+	if err != nil {
+		return invalid(FindingBadTagFile, "bagit.txt", fmt.Sprintf("Unable to parse bagit.txt: %v", err))
+	}
+
+	var missing []string
+	version, ok := declaration.get("BagIt-Version")
+	if !ok {
+		missing = append(missing, "BagIt-Version")
+	}
+	encoding, ok := declaration.get("Tag-File-Character-Encoding")
+	if !ok {
+		missing = append(missing, "Tag-File-Character-Encoding")
+	}
+	if len(missing) > 0 {
+		return invalid(
+			FindingBadTagFile, "bagit.txt",
+			fmt.Sprintf("Missing required tag in bagit.txt: %s", strings.Join(missing, ", ")),
+		)
+	}
+	if !slices.Contains(supportedVersions, version) {
+		return invalid(FindingBadTagFile, "bagit.txt", fmt.Sprintf("Unsupported bag version: %s", version))
+	}
+	if !strings.EqualFold(encoding, "UTF-8") {
+		return invalid(FindingBadTagFile, "bagit.txt", fmt.Sprintf("Unsupported encoding: %s", encoding))
+	}
+	b.version = version
+
+	if fi, err := fs.Stat(b.fsys, "data"); err != nil || !fi.IsDir() {
+		return invalid(
+			FindingInvalidBag, "data",
+			fmt.Sprintf("Expected data directory %s does not exist", filepath.Join(b.path, "data")),
+		)
+	}
+
+	if blob, err = b.readTagFile("bag-info.txt"); err != nil {
+		return err
+	}
+	if blob != nil {
+		if b.info, err = parseTags(blob); err != nil {
+			return invalid(FindingBadTagFile, "bag-info.txt", fmt.Sprintf("Unable to parse bag-info.txt: %v", err))
+		}
+	}
+
+	if err := b.loadManifests(); err != nil {
+		return err
+	}
+	if err := b.loadFetch(); err != nil {
+		return err
+	}
+
+	return b.loadPayload()
+}
+
+func (b *bag) loadManifests() error {
+	entries, err := fs.ReadDir(b.fsys, ".")
+	if err != nil {
+		return fmt.Errorf("read bag dir: %v", err)
+	}
+
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		name := e.Name()
+		prefix := "manifest"
+		alg, ok := manifestAlgorithm(prefix, name)
+		if !ok {
+			prefix = "tagmanifest"
+			if alg, ok = manifestAlgorithm(prefix, name); !ok {
+				continue
+			}
+		}
+		if newHash(alg) == nil {
+			return invalid(
+				FindingBadTagFile, name,
+				fmt.Sprintf("Unsupported checksum algorithm %q in %s", alg, name),
+			)
+		}
+
+		blob, err := b.readTagFile(name)
+		if err != nil {
+			return err
+		}
+		m, err := parseManifest(name, alg, blob, b.version != "0.97")
+		if err != nil {
+			return invalid(FindingBadTagFile, name, fmt.Sprintf("Unable to parse %s: %v", name, err))
+		}
+
+		if prefix == "manifest" {
+			b.manifests = append(b.manifests, m)
+		} else {
+			b.tagManifests = append(b.tagManifests, m)
+		}
+	}
+
+	if len(b.manifests) == 0 {
+		return invalid(FindingBadTagFile, "", "No manifest files found")
+	}
+
+	return nil
+}
+
+func (b *bag) loadFetch() error {
+	blob, err := b.readTagFile("fetch.txt")
+	if err != nil || blob == nil {
+		return err
+	}
+
+	for line := range strings.Lines(string(blob)) {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return invalid(FindingBadTagFile, "fetch.txt", fmt.Sprintf("Malformed line in fetch.txt: %s", line))
+		}
+		e := fetchEntry{
+			url:    fields[0],
+			length: fields[1],
+			path:   strings.Join(fields[2:], " "),
+		}
+		if b.version != "0.97" {
+			e.path = decodePath(e.path)
+		}
+
+		if !strings.Contains(e.url, "://") {
+			return invalid(FindingBadTagFile, "fetch.txt", fmt.Sprintf("Malformed URL in fetch.txt: %s", e.url))
+		}
+		if _, err := strconv.ParseUint(e.length, 10, 64); err != nil && e.length != "-" {
+			return invalid(
+				FindingBadTagFile, "fetch.txt",
+				fmt.Sprintf("Malformed length in fetch.txt: %s", e.length),
+			)
+		}
+		if !safePath(e.path) || !strings.HasPrefix(filepath.ToSlash(filepath.Clean(e.path)), "data/") {
+			return invalid(
+				FindingBadTagFile, "fetch.txt",
+				fmt.Sprintf("Path %q in fetch.txt is unsafe", e.path),
+			)
+		}
+
+		b.fetch = append(b.fetch, e)
+	}
+
+	return nil
+}
+
+func (b *bag) loadPayload() error {
+	return fs.WalkDir(b.fsys, "data", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		fi, err := fs.Stat(b.fsys, p)
+		if err != nil {
+			return fmt.Errorf("stat payload file: %v", err)
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		b.payload[p] = fi.Size()
+
+		return nil
+	})
+}
+
+// validate checks the Payload-Oxum, the completeness and the checksums of the
+// Bag, in that order, stopping after the checks required by mode and returning
+// a *ValidationError on the first failed check.
+func (b *bag) validate(ctx context.Context, mode Mode, t *tracker) error {
+	if mode == ModeOxum {
+		if _, ok := b.info.get("Payload-Oxum"); !ok {
+			return invalid(
+				FindingBadTagFile,
+				"bag-info.txt",
+				"Fast validation requires bag-info.txt to include Payload-Oxum",
+			)
+		}
+	}
+	if err := b.validateOxum(); err != nil {
+		return err
+	}
+	if mode == ModeOxum {
+		return nil
+	}
+
+	if err := b.validateCompleteness(); err != nil {
+		return err
+	}
+	if mode == ModeComplete {
+		return nil
+	}
+
+	return b.validateChecksums(ctx, t)
+}
+
+func (b *bag) validateOxum() error {
+	oxum, ok := b.info.get("Payload-Oxum")
+	if !ok {
+		return nil
+	}
+
+	bytesStr, countStr, ok := strings.Cut(oxum, ".")
+	size, serr := strconv.ParseInt(bytesStr, 10, 64)
+	count, cerr := strconv.Atoi(countStr)
+	if !ok || serr != nil || cerr != nil {
+		return invalid(FindingBadTagFile, "bag-info.txt", fmt.Sprintf("Malformed Payload-Oxum value: %s", oxum))
+	}
+
+	var foundSize int64
+	for _, s := range b.payload {
+		foundSize += s
+	}
+	foundCount := len(b.payload)
+
+	if size != foundSize || count != foundCount {
+		msg := fmt.Sprintf(
+			"Payload-Oxum validation failed. Expected %d files and %d bytes but found %d files and %d bytes",
+			count, size, foundCount, foundSize,
+		)
+		return &ValidationError{
+			Message: msg,
+			Findings: []Finding{{
+				Code:     FindingOxumMismatch,
+				Expected: oxum,
+				Actual:   fmt.Sprintf("%d.%d", foundSize, foundCount),
+				Message:  msg,
+			}},
+		}
+	}
+
+	return nil
+}
+
+// exists returns true if the Bag relative path p is a file in the Bag.
+func (b *bag) exists(p string) bool {
+	if _, ok := b.payload[p]; ok {
+		return true
+	}
+	fi, err := fs.Stat(b.fsys, p)
+
+	return err == nil && !fi.IsDir()
+}
+
+// validateCompleteness checks that the files listed in the manifests and tag
+// manifests exist and that every payload file is listed in every payload
+// manifest.
+func (b *bag) validateCompleteness() error {
+	listed := make(map[string]struct{})
+	for _, m := range slices.Concat(b.manifests, b.tagManifests) {
+		for p := range m.entries {
+			listed[p] = struct{}{}
+		}
+	}
+
+	var findings []Finding
+	for _, p := range sortedKeys(listed) {
+		if !b.exists(p) {
+			findings = append(findings, Finding{
+				Code:    FindingMissingFile,
+				Path:    p,
+				Message: fmt.Sprintf("%s exists in manifest but was not found on filesystem", p),
+			})
+		}
+	}
+	for _, p := range sortedKeys(b.payload) {
+		var unlisted []string
+		for _, m := range b.manifests {
+			if _, ok := m.entries[p]; !ok {
+				unlisted = append(unlisted, m.name)
+			}
+		}
+		if len(unlisted) == len(b.manifests) {
+			findings = append(findings, Finding{
+				Code:    FindingExtraFile,
+				Path:    p,
+				Message: fmt.Sprintf("%s exists on filesystem but is not in the manifest", p),
+			})
+			continue
+		}
+		for _, name := range unlisted {
+			findings = append(findings, Finding{
+				Code:    FindingExtraFile,
+				Path:    p,
+				Message: fmt.Sprintf("%s exists on filesystem but is not in %s", p, name),
+			})
+		}
+	}
+
+	return failed(findings)
+}
+
+func (b *bag) validateChecksums(ctx context.Context, t *tracker) error {
+	// Group the expected checksums by path, to read each file only once.
+	expected := make(map[string]map[string]string)
+	for _, m := range slices.Concat(b.manifests, b.tagManifests) {
+		for p, sum := range m.entries {
+			if expected[p] == nil {
+				expected[p] = make(map[string]string)
+			}
+			expected[p][m.alg] = sum
+		}
+	}
+
+	var findings []Finding
+	for _, p := range sortedKeys(expected) {
+		sums, err := b.checksums(ctx, p, sortedKeys(expected[p]), t)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("generate checksums: %v", err)
+		}
+		t.addFile()
+
+		for _, alg := range sortedKeys(expected[p]) {
+			if sums[alg] != expected[p][alg] {
+				findings = append(findings, Finding{
+					Code:      FindingChecksumMismatch,
+					Path:      p,
+					Algorithm: alg,
+					Expected:  expected[p][alg],
+					Actual:    sums[alg],
+					Message: fmt.Sprintf(
+						"%s %s validation failed: expected=%q found=%q",
+						p, alg, expected[p][alg], sums[alg],
+					),
+				})
+			}
+		}
+	}
+
+	return failed(findings)
+}
+
+// checksums returns the checksums of the Bag file at p for each of the given
+// algorithms, reading the file only once and tracking the bytes read with t.
+// The checksums generated while loading a tar archive are used if available.
+func (b *bag) checksums(ctx context.Context, p string, algs []string, t *tracker) (map[string]string, error) {
+	if tf, ok := b.fsys.(*tarFS); ok {
+		if sums, ok := tf.checksums(p); ok {
+			return sums, nil
+		}
+	}
+
+	f, err := b.fsys.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mh := newMultiHash(algs)
+	if _, err := io.Copy(io.MultiWriter(mh, t), &ctxReader{ctx: ctx, r: f}); err != nil {
+		return nil, err
+	}
+
+	return mh.sums(), nil
+}
+
+// tracker is an io.Writer counting the hashed bytes and reporting the
+// validation progress.
+type tracker struct {
+	progress Progress
+	report   func(Progress)
+}
+
+func (t *tracker) Write(p []byte) (int, error) {
+	t.progress.Bytes += int64(len(p))
+	if t.report != nil {
+		t.report(t.progress)
+	}
+	return len(p), nil
+}
+
+// addFile adds a verified file to the progress.
+func (t *tracker) addFile() {
+	t.progress.Files++
+	if t.report != nil {
+		t.report(t.progress)
+	}
+}
+
+// ctxReader is an io.Reader that stops reading when ctx is cancelled.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// failed returns a *ValidationError with the given findings, or nil if there
+// are no findings.
+func failed(findings []Finding) error {
+	if len(findings) == 0 {
+		return nil
+	}
+
+	details := make([]string, len(findings))
+	for i, f := range findings {
+		details[i] = f.Message
+	}
+
+	return &ValidationError{
+		Message:  "Bag validation failed: " + strings.Join(details, "; "),
+		Findings: findings,
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
@@ -0,0 +1,594 @@
+package bagvalidate_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"gotest.tools/v3/assert"
+	tfs "gotest.tools/v3/fs"
+
+	"github.com/artefactual-sdps/temporal-activities/bagvalidate"
+)
+
+const (
+	smallSHA512 = "8cbdd4ed5452f7c066509c066d5ea87fc03f30b0c67153624a1bce4d6e14b6709b5e78caf723cdf419d0efad4db96ba1cad3196783c26a7743029459bdd148b0"
+	bagitTxt    = "BagIt-Version: 0.97\nTag-File-Character-Encoding: UTF-8\n"
+)
+
+func testBag(t *testing.T, ops ...tfs.PathOp) string {
+	t.Helper()
+
+	ops = append([]tfs.PathOp{
+		tfs.WithFile("bagit.txt", bagitTxt),
+		tfs.WithFile("manifest-sha512.txt", sha512manifest),
+		tfs.WithDir("data",
+			tfs.WithFile("another.txt", "I am another file.\n"),
+			tfs.WithFile("small.txt", "I am a small file.\n"),
+		),
+	}, ops...)
+
+	return tfs.NewDir(t, "temporal-activities-test", ops...).Path()
+}
+
+func TestNativeValidator(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		name         string
+		path         string
+		wantErr      string
+		wantFindings []bagvalidate.Finding
+	}
+	for _, tt := range []test{
+		{
+			name: "Validates a bag",
+			path: validTestBag(t),
+		},
+		{
+			name: "Validates a bag with a tag manifest",
+			path: testBag(t,
+				tfs.WithFile("tagmanifest-md5.txt", fmt.Sprintf(
+					"%s  bagit.txt\n%s  manifest-sha512.txt\n",
+					"9e5ad981e0d29adc278f6a294b8c2aca",
+					"12df34241580e9c38a64410a5278ff91",
+				)),
+			),
+		},
+		{
+			name: "Returns a tag manifest mismatch",
+			path: testBag(t,
+				tfs.WithFile("tagmanifest-md5.txt", fmt.Sprintf(
+					"%s  bagit.txt\n%s  manifest-sha512.txt\n",
+					"9e5ad981e0d29adc278f6a294b8c2aca",
+					"68d4bc2ec6e4e4dc6e45d20bfd546d6f",
+				)),
+			),
+			wantErr: `invalid: Bag validation failed: manifest-sha512.txt md5 validation failed: expected="68d4bc2ec6e4e4dc6e45d20bfd546d6f" found="12df34241580e9c38a64410a5278ff91"`,
+			wantFindings: []bagvalidate.Finding{{
+				Code:      bagvalidate.FindingChecksumMismatch,
+				Path:      "manifest-sha512.txt",
+				Algorithm: "md5",
+				Expected:  "68d4bc2ec6e4e4dc6e45d20bfd546d6f",
+				Actual:    "12df34241580e9c38a64410a5278ff91",
+			}},
+		},
+		{
+			name: "Validates a BagIt 1.0 bag with encoded paths",
+			path: tfs.NewDir(t, "temporal-activities-test",
+				tfs.WithFile("bagit.txt", "BagIt-Version: 1.0\nTag-File-Character-Encoding: UTF-8\n"),
+				tfs.WithFile("manifest-sha512.txt", smallSHA512+"  data/100%25 small.txt\n"),
+				tfs.WithDir("data", tfs.WithFile("100% small.txt", "I am a small file.\n")),
+			).Path(),
+		},
+		{
+			name:    "Returns an oxum mismatch",
+			path:    invalidTestBag(t),
+			wantErr: "invalid: Payload-Oxum validation failed. Expected 2 files and 38 bytes but found 1 files and 19 bytes",
+			wantFindings: []bagvalidate.Finding{{
+				Code:     bagvalidate.FindingOxumMismatch,
+				Expected: "38.2",
+				Actual:   "19.1",
+				Message:  "Payload-Oxum validation failed. Expected 2 files and 38 bytes but found 1 files and 19 bytes",
+			}},
+		},
+		{
+			name: "Returns missing and extra files",
+			path: testBag(t,
+				tfs.WithFile("manifest-sha512.txt", sha512manifest+smallSHA512+"  data/missing.txt\n"),
+				tfs.WithDir("data", tfs.WithFile("extra.txt", "Extra")),
+			),
+			wantErr: "invalid: Bag validation failed: " +
+				"data/missing.txt exists in manifest but was not found on filesystem; " +
+				"data/extra.txt exists on filesystem but is not in the manifest",
+			wantFindings: []bagvalidate.Finding{
+				{
+					Code:    bagvalidate.FindingMissingFile,
+					Path:    "data/missing.txt",
+					Message: "data/missing.txt exists in manifest but was not found on filesystem",
+				},
+				{
+					Code:    bagvalidate.FindingExtraFile,
+					Path:    "data/extra.txt",
+					Message: "data/extra.txt exists on filesystem but is not in the manifest",
+				},
+			},
+		},
+		{
+			name: "Returns an extra file listed only in a tag manifest",
+			path: testBag(t,
+				tfs.WithFile("tagmanifest-sha512.txt", smallSHA512+"  data/extra.txt\n"),
+				tfs.WithDir("data", tfs.WithFile("extra.txt", "I am a small file.\n")),
+			),
+			wantErr: "invalid: Bag validation failed: data/extra.txt exists on filesystem but is not in the manifest",
+			wantFindings: []bagvalidate.Finding{{
+				Code: bagvalidate.FindingExtraFile,
+				Path: "data/extra.txt",
+			}},
+		},
+		{
+			name: "Returns a file missing from one of the payload manifests",
+			path: testBag(t,
+				tfs.WithFile("manifest-md5.txt", "fbdea08bab9d1c2f39f486f92f85a673  data/small.txt\n"),
+			),
+			wantErr: "invalid: Bag validation failed: data/another.txt exists on filesystem but is not in manifest-md5.txt",
+			wantFindings: []bagvalidate.Finding{{
+				Code: bagvalidate.FindingExtraFile,
+				Path: "data/another.txt",
+			}},
+		},
+		{
+			name: "Returns a missing file",
+			path: tfs.NewDir(t, "temporal-activities-test",
+				tfs.WithFile("bagit.txt", bagitTxt),
+				tfs.WithFile("manifest-sha512.txt", sha512manifest),
+				tfs.WithDir("data", tfs.WithFile("small.txt", "I am a small file.\n")),
+			).Path(),
+			wantErr: "invalid: Bag validation failed: data/another.txt exists in manifest but was not found on filesystem",
+			wantFindings: []bagvalidate.Finding{{
+				Code:    bagvalidate.FindingMissingFile,
+				Path:    "data/another.txt",
+				Message: "data/another.txt exists in manifest but was not found on filesystem",
+			}},
+		},
+		{
+			name: "Returns a checksum mismatch",
+			path: tfs.NewDir(t, "temporal-activities-test",
+				tfs.WithFile("bagit.txt", bagitTxt),
+				tfs.WithFile("manifest-sha512.txt", sha512manifest),
+				tfs.WithDir("data",
+					tfs.WithFile("another.txt", "I am another file.\n"),
+					tfs.WithFile("small.txt", "I am a changed file.\n"),
+				),
+			).Path(),
+			wantErr: fmt.Sprintf(
+				`invalid: Bag validation failed: data/small.txt sha512 validation failed: expected="%s" found="%s"`,
+				smallSHA512,
+				"9730172a58d0fe584ccd0d74412c1996861d091114a1a32a8a30736b29d7f819b00cb887da388f670dadac377dee3465d6b79030ed1890d403bcc8d834c60a12",
+			),
+		},
+		{
+			name: "Returns a missing bagit.txt",
+			path: tfs.NewDir(t, "temporal-activities-test", tfs.WithDir("data")).Path(),
+			wantFindings: []bagvalidate.Finding{{
+				Code: bagvalidate.FindingBadTagFile,
+				Path: "bagit.txt",
+			}},
+		},
+		{
+			name:    "Returns an unsupported version",
+			path:    testBag(t, tfs.WithFile("bagit.txt", "BagIt-Version: 0.96\nTag-File-Character-Encoding: UTF-8\n")),
+			wantErr: "invalid: Unsupported bag version: 0.96",
+			wantFindings: []bagvalidate.Finding{{
+				Code:    bagvalidate.FindingBadTagFile,
+				Path:    "bagit.txt",
+				Message: "Unsupported bag version: 0.96",
+			}},
+		},
+		{
+			name:    "Returns a missing required tag",
+			path:    testBag(t, tfs.WithFile("bagit.txt", "BagIt-Version: 0.97\n")),
+			wantErr: "invalid: Missing required tag in bagit.txt: Tag-File-Character-Encoding",
+		},
+		{
+			name:    "Returns a missing manifest",
+			path:    tfs.NewDir(t, "temporal-activities-test", tfs.WithFile("bagit.txt", bagitTxt), tfs.WithDir("data")).Path(),
+			wantErr: "invalid: No manifest files found",
+		},
+		{
+			name:    "Returns a malformed manifest",
+			path:    testBag(t, tfs.WithFile("manifest-md5.txt", "abc\n")),
+			wantErr: `invalid: Unable to parse manifest-md5.txt: malformed line "abc"`,
+		},
+		{
+			name:    "Returns an unsafe manifest path",
+			path:    testBag(t, tfs.WithFile("manifest-md5.txt", "abc  ../../etc/passwd\n")),
+			wantErr: `invalid: Unable to parse manifest-md5.txt: unsafe path "../../etc/passwd"`,
+		},
+		{
+			name: "Returns a file listed in fetch.txt but not fetched",
+			path: tfs.NewDir(t, "temporal-activities-test",
+				tfs.WithFile("bagit.txt", bagitTxt),
+				tfs.WithFile("manifest-sha512.txt", sha512manifest),
+				tfs.WithFile("fetch.txt", "https://example.com/another.txt 19 data/another.txt\n"),
+				tfs.WithDir("data", tfs.WithFile("small.txt", "I am a small file.\n")),
+			).Path(),
+			wantErr: "invalid: Bag validation failed: data/another.txt exists in manifest but was not found on filesystem",
+		},
+		{
+			name: "Validates a bag with fetched files",
+			path: testBag(t,
+				tfs.WithFile("fetch.txt", "https://example.com/another.txt - data/another.txt\n"),
+			),
+		},
+		{
+			name:    "Returns a malformed fetch.txt",
+			path:    testBag(t, tfs.WithFile("fetch.txt", "https://example.com/another.txt 19\n")),
+			wantErr: "invalid: Malformed line in fetch.txt: https://example.com/another.txt 19",
+		},
+		{
+			name:    "Returns an unsafe fetch.txt path",
+			path:    testBag(t, tfs.WithFile("fetch.txt", "https://example.com/x 1 ../x\n")),
+			wantErr: `invalid: Path "../x" in fetch.txt is unsafe`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := bagvalidate.NewNativeValidator().Validate(tt.path)
+			if tt.wantErr == "" && tt.wantFindings == nil {
+				assert.NilError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, bagvalidate.ErrInvalid)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+			}
+
+			var verr *bagvalidate.ValidationError
+			assert.Assert(t, errors.As(err, &verr))
+			if tt.wantFindings != nil {
+				for i := range verr.Findings {
+					if tt.wantFindings[i].Message == "" {
+						verr.Findings[i].Message = ""
+					}
+				}
+				assert.DeepEqual(t, verr.Findings, tt.wantFindings)
+			}
+		})
+	}
+}
+
+func TestNativeValidatorMatchesGython(t *testing.T) {
+	t.Parallel()
+
+	gython := setup(t)
+	native := bagvalidate.NewNativeValidator()
+
+	for _, path := range []string{validTestBag(t), invalidTestBag(t)} {
+		gerr := gython.Validate(path)
+		nerr := native.Validate(path)
+		assert.Equal(t, errors.Is(nerr, bagvalidate.ErrInvalid), gerr != nil)
+		if gerr != nil {
+			assert.Error(t, nerr, gerr.Error())
+		}
+	}
+}
+
+func TestNativeValidatorConcurrency(t *testing.T) {
+	t.Parallel()
+
+	v := bagvalidate.NewNativeValidator()
+	valid, invalid := validTestBag(t), invalidTestBag(t)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			assert.Check(t, v.Validate(valid))
+			assert.Check(t, errors.Is(v.Validate(invalid), bagvalidate.ErrInvalid))
+		})
+	}
+	wg.Wait()
+}
+
+func TestNativeValidatorContext(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Reports the validation progress", func(t *testing.T) {
+		t.Parallel()
+
+		var got bagvalidate.Progress
+		err := bagvalidate.NewNativeValidator().ValidateContext(
+			context.Background(),
+			validTestBag(t),
+			bagvalidate.ModeFull,
+			func(p bagvalidate.Progress) { got = p },
+		)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, got, bagvalidate.Progress{Files: 2, Bytes: 38})
+	})
+
+	t.Run("Reports the validation progress of a tar.gz bag", func(t *testing.T) {
+		t.Parallel()
+
+		var got bagvalidate.Progress
+		err := bagvalidate.NewNativeValidator().ValidateContext(
+			context.Background(),
+			serializeBag(t, validTestBag(t), "tar.gz", "bag"),
+			bagvalidate.ModeFull,
+			func(p bagvalidate.Progress) { got = p },
+		)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, got, bagvalidate.Progress{Files: 2, Bytes: 38})
+	})
+
+	t.Run("Stops when the context is cancelled", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := bagvalidate.NewNativeValidator().ValidateContext(ctx, validTestBag(t), bagvalidate.ModeFull, nil)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+// serializeBag writes the Bag directory at path to an archive with the given
+// format ("tar", "tar.gz" or "zip"), under the top-level directory dir if not
+// empty.
+func serializeBag(t *testing.T, path, format, dir string) string {
+	t.Helper()
+
+	dest := filepath.Join(t.TempDir(), "bag."+format)
+	f, err := os.Create(dest)
+	assert.NilError(t, err)
+	defer f.Close()
+
+	var add func(name string, info fs.FileInfo, content []byte) error
+	switch format {
+	case "tar", "tar.gz":
+		var w io.Writer = f
+		if format == "tar.gz" {
+			zw := gzip.NewWriter(f)
+			defer func() { assert.NilError(t, zw.Close()) }()
+			w = zw
+		}
+		tw := tar.NewWriter(w)
+		defer func() { assert.NilError(t, tw.Close()) }()
+		add = func(name string, info fs.FileInfo, content []byte) error {
+			hdr, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			hdr.Name = name
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			_, err = tw.Write(content)
+			return err
+		}
+	case "zip":
+		zw := zip.NewWriter(f)
+		defer func() { assert.NilError(t, zw.Close()) }()
+		add = func(name string, info fs.FileInfo, content []byte) error {
+			if info.IsDir() {
+				_, err := zw.Create(name + "/")
+				return err
+			}
+			w, err := zw.Create(name)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(content)
+			return err
+		}
+	}
+
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == path {
+			return err
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var content []byte
+		if !d.IsDir() {
+			if content, err = os.ReadFile(p); err != nil {
+				return err
+			}
+		}
+		return add(filepath.ToSlash(filepath.Join(dir, rel)), info, content)
+	})
+	assert.NilError(t, err)
+
+	return dest
+}
+
+func TestNativeValidatorArchive(t *testing.T) {
+	t.Parallel()
+
+	changedBag := func(t *testing.T) string {
+		return testBag(t, tfs.WithDir("data", tfs.WithFile("small.txt", "I am a SMALL file.\n")))
+	}
+
+	type test struct {
+		name    string
+		path    string
+		mode    bagvalidate.Mode
+		wantErr string
+	}
+	for _, tt := range []test{
+		{
+			name: "Validates a tar bag",
+			path: serializeBag(t, validTestBag(t), "tar", "bag"),
+		},
+		{
+			name: "Validates a tar.gz bag",
+			path: serializeBag(t, validTestBag(t), "tar.gz", "bag"),
+		},
+		{
+			name: "Validates a tar.gz bag with a tag manifest",
+			path: serializeBag(t, testBag(t,
+				tfs.WithFile("tagmanifest-md5.txt", fmt.Sprintf(
+					"%s  bagit.txt\n%s  manifest-sha512.txt\n",
+					"9e5ad981e0d29adc278f6a294b8c2aca",
+					"12df34241580e9c38a64410a5278ff91",
+				)),
+			), "tar.gz", ""),
+		},
+		{
+			name: "Validates a tar bag with a manifest before the payload",
+			path: tarBag(t,
+				"bagit.txt", bagitTxt,
+				"manifest-md5.txt", "6d98727295350bb2a1cb8f957bd210c4  data/another.txt\n"+
+					"fbdea08bab9d1c2f39f486f92f85a673  data/small.txt\n",
+				"data/another.txt", "I am another file.\n",
+				"data/small.txt", "I am a small file.\n",
+			),
+		},
+		{
+			name: "Returns a checksum mismatch of a manifest after the payload in a tar bag",
+			path: tarBag(t,
+				"bagit.txt", bagitTxt,
+				"manifest-md5.txt", "6d98727295350bb2a1cb8f957bd210c4  data/another.txt\n"+
+					"a8107f1c8114a04ae896cb61cc73b09a  data/small.txt\n",
+				"data/another.txt", "I am another file.\n",
+				"data/small.txt", "I am a SMALL file.\n",
+				"manifest-sha512.txt", sha512manifest,
+			),
+			wantErr: fmt.Sprintf(
+				`invalid: Bag validation failed: data/small.txt sha512 validation failed: expected="%s" found="%s"`,
+				smallSHA512,
+				"b85e916f84e8c7df73eef54353f01b1daa0b814874d446b2657d2971872b5d312e9715538bd083ad95b6298ff43a4d497331732443b188efbaeaba76f520a0a7",
+			),
+		},
+		{
+			name: "Validates a zip bag",
+			path: serializeBag(t, validTestBag(t), "zip", "bag"),
+		},
+		{
+			name: "Validates a zip bag without a top-level directory",
+			path: serializeBag(t, validTestBag(t), "zip", ""),
+		},
+		{
+			name:    "Returns an oxum mismatch in a tar bag",
+			path:    serializeBag(t, invalidTestBag(t), "tar", "bag"),
+			wantErr: "invalid: Payload-Oxum validation failed. Expected 2 files and 38 bytes but found 1 files and 19 bytes",
+		},
+		{
+			name: "Returns a checksum mismatch in a zip bag",
+			path: serializeBag(t, changedBag(t), "zip", "bag"),
+			wantErr: fmt.Sprintf(
+				`invalid: Bag validation failed: data/small.txt sha512 validation failed: expected="%s" found="%s"`,
+				smallSHA512,
+				"b85e916f84e8c7df73eef54353f01b1daa0b814874d446b2657d2971872b5d312e9715538bd083ad95b6298ff43a4d497331732443b188efbaeaba76f520a0a7",
+			),
+		},
+		{
+			name: "Returns a checksum mismatch in a tar.gz bag",
+			path: serializeBag(t, changedBag(t), "tar.gz", "bag"),
+			wantErr: fmt.Sprintf(
+				`invalid: Bag validation failed: data/small.txt sha512 validation failed: expected="%s" found="%s"`,
+				smallSHA512,
+				"b85e916f84e8c7df73eef54353f01b1daa0b814874d446b2657d2971872b5d312e9715538bd083ad95b6298ff43a4d497331732443b188efbaeaba76f520a0a7",
+			),
+		},
+		{
+			name: "Returns a missing file in a tar.gz bag",
+			path: serializeBag(t, tfs.NewDir(t, "temporal-activities-test",
+				tfs.WithFile("bagit.txt", bagitTxt),
+				tfs.WithFile("manifest-sha512.txt", sha512manifest),
+				tfs.WithDir("data", tfs.WithFile("small.txt", "I am a small file.\n")),
+			).Path(), "tar.gz", "bag"),
+			wantErr: "invalid: Bag validation failed: data/another.txt exists in manifest but was not found on filesystem",
+		},
+		{
+			name: "Skips checksums of a tar bag in complete mode",
+			path: serializeBag(t, changedBag(t), "tar", "bag"),
+			mode: bagvalidate.ModeComplete,
+		},
+		{
+			name:    "Returns a missing bagit.txt in an archive",
+			path:    writeFile(t, "bag.zip", emptyZip(t)),
+			wantErr: "invalid: Expected bagit.txt does not exist: ",
+		},
+		{
+			name:    "Errors if the archive format is not supported",
+			path:    writeFile(t, "bag.7z", nil),
+			wantErr: `unsupported archive format ".7z", must be one of (.tar, .tar.gz, .tgz, .zip)`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := bagvalidate.NewNativeValidator().ValidateMode(tt.path, tt.mode)
+			if tt.wantErr == "" {
+				assert.NilError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+
+	t.Run("Validates a tar bag with the gython validator", func(t *testing.T) {
+		t.Parallel()
+
+		err := bagvalidate.NewGythonValidator().Validate(serializeBag(t, validTestBag(t), "tar", "bag"))
+		assert.NilError(t, err)
+	})
+}
+
+// tarBag writes a tar archive with the given pairs of file names and contents,
+// in order, and returns its path.
+func tarBag(t *testing.T, files ...string) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i := 0; i < len(files); i += 2 {
+		assert.NilError(t, tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     files[i],
+			Mode:     0o600,
+			Size:     int64(len(files[i+1])),
+		}))
+		_, err := tw.Write([]byte(files[i+1]))
+		assert.NilError(t, err)
+	}
+	assert.NilError(t, tw.Close())
+
+	return writeFile(t, "bag.tar", buf.Bytes())
+}
+
+func writeFile(t *testing.T, name string, content []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	assert.NilError(t, os.WriteFile(path, content, 0o600))
+
+	return path
+}
+
+func emptyZip(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	assert.NilError(t, zip.NewWriter(&buf).Close())
+
+	return buf.Bytes()
+}
@@ -0,0 +1,196 @@
+package bagvalidate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	bagit_gython "github.com/artefactual-labs/bagit-gython"
+)
+
+// CleanupValidator is a BagValidator holding resources that are released by
+// Cleanup, like bagit_gython.BagIt.
+type CleanupValidator interface {
+	BagValidator
+	Cleanup() error
+}
+
+// ErrClosed is returned when validating with a closed poolValidator.
+var ErrClosed = errors.New("validator pool is closed")
+
+// poolValidator is a BagValidator that manages a bounded pool of validator
+// instances, so Bags can be validated concurrently without creating a new
+// instance for each validation. Instances are created when needed, up to the
+// pool size, and each validation checks out an instance for its exclusive
+// use. It's safe for concurrent use.
+type poolValidator struct {
+	factory func() (CleanupValidator, error)
+
+	// idle holds the instances available for checkout.
+	idle chan CleanupValidator
+
+	// slots limits the number of instances created.
+	slots chan struct{}
+
+	// done is closed by Close to wake up the validations waiting for an
+	// instance.
+	done chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewPoolValidator creates a new instance of poolValidator with up to size
+// validator instances created with factory. If factory is nil, bagit-gython
+// instances are created. Close must be called to clean up the instances when
+// the pool is no longer needed.
+func NewPoolValidator(size int, factory func() (CleanupValidator, error)) (*poolValidator, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("invalid pool size %d, must be greater than zero", size)
+	}
+	if factory == nil {
+		factory = func() (CleanupValidator, error) {
+			return bagit_gython.NewBagIt()
+		}
+	}
+
+	return &poolValidator{
+		factory: factory,
+		idle:    make(chan CleanupValidator, size),
+		slots:   make(chan struct{}, size),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Validate validates the Bag at path with a pooled instance, waiting for one
+// to be available.
+func (p *poolValidator) Validate(path string) error {
+	return p.ValidateContext(context.Background(), path, ModeFull, nil)
+}
+
+// ValidateMode validates the Bag at path using the given mode.
+func (p *poolValidator) ValidateMode(path string, mode Mode) error {
+	return p.ValidateContext(context.Background(), path, mode, nil)
+}
+
+// ValidateContext validates the Bag at path using the given mode, and stops
+// waiting for an instance or for the validation when ctx is cancelled. The
+// pooled instances are only used for full validations of Bag directories,
+// the native validator is used for the other modes and for serialized Bags.
+func (p *poolValidator) ValidateContext(
+	ctx context.Context,
+	path string,
+	mode Mode,
+	progress func(Progress),
+) error {
+	if mode.String() != string(ModeFull) || isArchive(path) {
+		return NewNativeValidator().ValidateContext(ctx, path, mode, progress)
+	}
+
+	v, err := p.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The instance is released when the validation ends, even if ctx is
+	// cancelled first, so it's never used by two validations at once.
+	done := make(chan error, 1)
+	go func() {
+		err := v.Validate(path)
+		p.release(v, err)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// acquire checks out an idle instance, creating a new one if the pool isn't
+// full, or waits for an instance to be released. It returns ErrClosed if the
+// pool is closed before an instance is available.
+func (p *poolValidator) acquire(ctx context.Context) (CleanupValidator, error) {
+	if p.isClosed() {
+		return nil, ErrClosed
+	}
+
+	select {
+	case v := <-p.idle:
+		return v, nil
+	default:
+	}
+
+	select {
+	case v := <-p.idle:
+		return v, nil
+	case p.slots <- struct{}{}:
+		v, err := p.factory()
+		if err != nil {
+			<-p.slots
+			return nil, fmt.Errorf("create validator: %v", err)
+		}
+		return v, nil
+	case <-p.done:
+		return nil, ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// release returns v to the pool after a validation that returned err. v is
+// cleaned up instead if the pool is closed or if err isn't a validation error,
+// as the instance may be broken, freeing its slot for a new instance.
+func (p *poolValidator) release(v CleanupValidator, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		_ = v.Cleanup()
+		return
+	}
+	if err != nil && !errors.Is(convertError(err), ErrInvalid) {
+		_ = v.Cleanup()
+		<-p.slots
+		return
+	}
+	p.idle <- v
+}
+
+func (p *poolValidator) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// Close cleans up the idle instances and closes the pool, instances in use
+// are cleaned up when their validation ends. Validations waiting for an
+// instance or started after Close return ErrClosed.
+func (p *poolValidator) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+
+	var errs []error
+	for {
+		select {
+		case v := <-p.idle:
+			errs = append(errs, v.Cleanup())
+		default:
+			return errors.Join(errs...)
+		}
+	}
+}
+
+var (
+	_ ModeValidator    = (*poolValidator)(nil)
+	_ ContextValidator = (*poolValidator)(nil)
+)
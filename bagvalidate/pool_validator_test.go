@@ -0,0 +1,223 @@
+package bagvalidate_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	bagit_gython "github.com/artefactual-labs/bagit-gython"
+	"gotest.tools/v3/assert"
+
+	"github.com/artefactual-sdps/temporal-activities/bagvalidate"
+)
+
+// countingValidator is a bagvalidate.CleanupValidator tracking the number of
+// concurrent validations of all the instances created by its factory.
+type countingValidator struct {
+	active    *atomic.Int32
+	maxActive *atomic.Int32
+	cleaned   *atomic.Int32
+	release   chan struct{}
+	err       error
+}
+
+func (v countingValidator) Validate(string) error {
+	n := v.active.Add(1)
+	defer v.active.Add(-1)
+	for {
+		m := v.maxActive.Load()
+		if n <= m || v.maxActive.CompareAndSwap(m, n) {
+			break
+		}
+	}
+	if v.release != nil {
+		<-v.release
+	}
+
+	return v.err
+}
+
+func (v countingValidator) Cleanup() error {
+	v.cleaned.Add(1)
+	return nil
+}
+
+type counters struct {
+	created, active, maxActive, cleaned atomic.Int32
+
+	// err is returned by the validations of the created instances.
+	err error
+}
+
+func (c *counters) factory(release chan struct{}) func() (bagvalidate.CleanupValidator, error) {
+	return func() (bagvalidate.CleanupValidator, error) {
+		c.created.Add(1)
+		return countingValidator{
+			active:    &c.active,
+			maxActive: &c.maxActive,
+			cleaned:   &c.cleaned,
+			release:   release,
+			err:       c.err,
+		}, nil
+	}
+}
+
+func TestPoolValidator(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Validates concurrently with a bounded number of instances", func(t *testing.T) {
+		t.Parallel()
+
+		var c counters
+		v, err := bagvalidate.NewPoolValidator(2, c.factory(nil))
+		assert.NilError(t, err)
+
+		var wg sync.WaitGroup
+		for range 20 {
+			wg.Go(func() {
+				assert.Check(t, v.Validate(t.TempDir()))
+			})
+		}
+		wg.Wait()
+
+		assert.Assert(t, c.created.Load() <= 2)
+		assert.Assert(t, c.maxActive.Load() <= 2)
+
+		assert.NilError(t, v.Close())
+		assert.Equal(t, c.cleaned.Load(), c.created.Load())
+	})
+
+	t.Run("Stops waiting for an instance when the context is cancelled", func(t *testing.T) {
+		t.Parallel()
+
+		var c counters
+		release := make(chan struct{})
+		v, err := bagvalidate.NewPoolValidator(1, c.factory(release))
+		assert.NilError(t, err)
+
+		go func() { _ = v.Validate(t.TempDir()) }()
+		for c.active.Load() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		err = v.ValidateContext(ctx, t.TempDir(), bagvalidate.ModeFull, nil)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		assert.NilError(t, v.Close())
+		close(release)
+		for c.cleaned.Load() != 1 {
+			time.Sleep(time.Millisecond)
+		}
+		assert.Equal(t, c.created.Load(), int32(1))
+	})
+
+	t.Run("Errors after the pool is closed", func(t *testing.T) {
+		t.Parallel()
+
+		var c counters
+		v, err := bagvalidate.NewPoolValidator(1, c.factory(nil))
+		assert.NilError(t, err)
+		assert.NilError(t, v.Close())
+
+		assert.ErrorIs(t, v.Validate(t.TempDir()), bagvalidate.ErrClosed)
+		assert.Equal(t, c.created.Load(), int32(0))
+	})
+
+	t.Run("Wakes up the validations waiting for an instance when closed", func(t *testing.T) {
+		t.Parallel()
+
+		var c counters
+		release := make(chan struct{})
+		v, err := bagvalidate.NewPoolValidator(1, c.factory(release))
+		assert.NilError(t, err)
+
+		go func() { _ = v.Validate(t.TempDir()) }()
+		for c.active.Load() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		waiting := make(chan error, 1)
+		go func() { waiting <- v.Validate(t.TempDir()) }()
+		time.Sleep(10 * time.Millisecond)
+
+		assert.NilError(t, v.Close())
+		select {
+		case err := <-waiting:
+			assert.ErrorIs(t, err, bagvalidate.ErrClosed)
+		case <-time.After(time.Second):
+			t.Fatal("validation still waiting after Close")
+		}
+		close(release)
+	})
+
+	t.Run("Replaces an instance after a non-validation error", func(t *testing.T) {
+		t.Parallel()
+
+		c := counters{err: errors.New("broken pipe")}
+		v, err := bagvalidate.NewPoolValidator(1, c.factory(nil))
+		assert.NilError(t, err)
+		t.Cleanup(func() { _ = v.Close() })
+
+		assert.Error(t, v.Validate(t.TempDir()), "broken pipe")
+		assert.Error(t, v.Validate(t.TempDir()), "broken pipe")
+		assert.Equal(t, c.created.Load(), int32(2))
+		assert.Equal(t, c.cleaned.Load(), int32(2))
+	})
+
+	t.Run("Reuses an instance after a validation error", func(t *testing.T) {
+		t.Parallel()
+
+		c := counters{err: bagit_gython.ErrInvalid}
+		v, err := bagvalidate.NewPoolValidator(1, c.factory(nil))
+		assert.NilError(t, err)
+
+		assert.ErrorIs(t, v.Validate(t.TempDir()), bagit_gython.ErrInvalid)
+		assert.ErrorIs(t, v.Validate(t.TempDir()), bagit_gython.ErrInvalid)
+		assert.Equal(t, c.created.Load(), int32(1))
+
+		assert.NilError(t, v.Close())
+		assert.Equal(t, c.cleaned.Load(), int32(1))
+	})
+
+	t.Run("Errors if the instance can't be created", func(t *testing.T) {
+		t.Parallel()
+
+		v, err := bagvalidate.NewPoolValidator(1, func() (bagvalidate.CleanupValidator, error) {
+			return nil, errors.New("no python")
+		})
+		assert.NilError(t, err)
+		t.Cleanup(func() { _ = v.Close() })
+
+		assert.Error(t, v.Validate(t.TempDir()), "create validator: no python")
+	})
+
+	t.Run("Errors if the size is invalid", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := bagvalidate.NewPoolValidator(0, nil)
+		assert.Error(t, err, "invalid pool size 0, must be greater than zero")
+	})
+
+	t.Run("Validates with pooled bagit-gython instances", func(t *testing.T) {
+		t.Parallel()
+
+		v, err := bagvalidate.NewPoolValidator(2, nil)
+		assert.NilError(t, err)
+		t.Cleanup(func() { assert.NilError(t, v.Close()) })
+
+		valid, invalid := validTestBag(t), invalidTestBag(t)
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Go(func() {
+				assert.Check(t, v.Validate(valid))
+				assert.Check(t, errors.Is(v.Validate(invalid), bagit_gython.ErrInvalid))
+			})
+		}
+		wg.Wait()
+	})
+}
@@ -0,0 +1,163 @@
+package bagvalidate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ProfileViolation is a BagIt Profile requirement not met by a Bag.
+type ProfileViolation struct {
+	// Rule is the profile field with the requirement, e.g. "Bag-Info" or
+	// "Manifests-Allowed".
+	Rule string
+
+	// Message describes the violation.
+	Message string
+}
+
+// profile is a BagIt Profile, as described in the BagIt Profiles
+// specification. Only the fields checked by validate are decoded.
+type profile struct {
+	BagInfo              map[string]profileTag `json:"Bag-Info"`
+	ManifestsRequired    []string              `json:"Manifests-Required"`
+	ManifestsAllowed     []string              `json:"Manifests-Allowed"`
+	TagManifestsRequired []string              `json:"Tag-Manifests-Required"`
+	TagManifestsAllowed  []string              `json:"Tag-Manifests-Allowed"`
+	TagFilesRequired     []string              `json:"Tag-Files-Required"`
+	AllowFetch           *bool                 `json:"Allow-Fetch.txt"`
+	FetchRequired        bool                  `json:"Fetch.txt-Required"`
+	AcceptBagItVersion   []string              `json:"Accept-BagIt-Version"`
+}
+
+// profileTag is a bag-info.txt tag definition in a BagIt Profile.
+type profileTag struct {
+	Required   bool     `json:"required"`
+	Values     []string `json:"values"`
+	Repeatable *bool    `json:"repeatable"`
+}
+
+// loadProfile loads the BagIt Profile from the JSON file at path or from the
+// JSON document doc, only one of them can be set. It returns nil if both are
+// empty.
+func loadProfile(path, doc string) (*profile, error) {
+	if path != "" && doc != "" {
+		return nil, errors.New("only one of ProfilePath or Profile can be set")
+	}
+
+	blob := []byte(doc)
+	if path != "" {
+		var err error
+		blob, err = os.ReadFile(path) // #nosec G304 -- trusted file path.
+		if err != nil {
+			return nil, fmt.Errorf("read profile: %v", err)
+		}
+	}
+	if len(blob) == 0 {
+		return nil, nil
+	}
+
+	var p profile
+	if err := json.Unmarshal(blob, &p); err != nil {
+		return nil, fmt.Errorf("decode profile: %v", err)
+	}
+
+	return &p, nil
+}
+
+// validate returns the profile requirements not met by the Bag at path. A Bag
+// that can't be read is reported as a "BagIt" violation. It stops and returns
+// ctx.Err() if ctx is cancelled while reading a serialized Bag.
+func (p *profile) validate(ctx context.Context, path string) ([]ProfileViolation, error) {
+	b, err := openBag(ctx, path, false, nil)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			return []ProfileViolation{{Rule: "BagIt", Message: verr.Message}}, nil
+		}
+		return nil, err
+	}
+	defer b.close()
+
+	var violations []ProfileViolation
+	add := func(rule, format string, a ...any) {
+		violations = append(violations, ProfileViolation{Rule: rule, Message: fmt.Sprintf(format, a...)})
+	}
+
+	if len(p.AcceptBagItVersion) > 0 && !slices.Contains(p.AcceptBagItVersion, b.version) {
+		add("Accept-BagIt-Version", "BagIt version %s is not accepted (%s)",
+			b.version, strings.Join(p.AcceptBagItVersion, ", "))
+	}
+
+	for _, label := range sortedKeys(p.BagInfo) {
+		def := p.BagInfo[label]
+		values := b.info.values(label)
+		if len(values) == 0 {
+			if def.Required {
+				add("Bag-Info", "Required tag %s is missing from bag-info.txt", label)
+			}
+			continue
+		}
+		if def.Repeatable != nil && !*def.Repeatable && len(values) > 1 {
+			add("Bag-Info", "Tag %s is not repeatable", label)
+		}
+		if len(def.Values) > 0 {
+			for _, v := range values {
+				if !slices.Contains(def.Values, v) {
+					add("Bag-Info", "Tag %s has value %q, must be one of (%s)",
+						label, v, strings.Join(def.Values, ", "))
+				}
+			}
+		}
+	}
+
+	checkManifests := func(kind string, manifests []*manifest, required, allowed []string) {
+		algs := make([]string, len(manifests))
+		for i, m := range manifests {
+			algs[i] = m.alg
+		}
+		slices.Sort(algs)
+		for _, alg := range required {
+			if !slices.Contains(algs, alg) {
+				add(kind+"s-Required", "Required %s algorithm %s is missing", strings.ToLower(kind), alg)
+			}
+		}
+		if len(allowed) > 0 {
+			for _, alg := range algs {
+				if !slices.Contains(allowed, alg) {
+					add(kind+"s-Allowed", "%s algorithm %s is not allowed", kind, alg)
+				}
+			}
+		}
+	}
+	checkManifests("Manifest", b.manifests, p.ManifestsRequired, p.ManifestsAllowed)
+	checkManifests("Tag-Manifest", b.tagManifests, p.TagManifestsRequired, p.TagManifestsAllowed)
+
+	for _, name := range p.TagFilesRequired {
+		if !safePath(name) {
+			return nil, fmt.Errorf("unsafe required tag file path %q", name)
+		}
+		if _, err := fs.Stat(b.fsys, name); err != nil {
+			add("Tag-Files-Required", "Required tag file %s is missing", name)
+		}
+	}
+
+	_, err = fs.Stat(b.fsys, "fetch.txt")
+	hasFetch := err == nil
+	if hasFetch && p.AllowFetch != nil && !*p.AllowFetch {
+		add("Allow-Fetch.txt", "fetch.txt is not allowed")
+	}
+	if !hasFetch && p.FetchRequired {
+		add("Fetch.txt-Required", "fetch.txt is required")
+	}
+
+	return violations, nil
+}
@@ -0,0 +1,209 @@
+package bagvalidate
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"  // #nosec G501 -- md5 is a valid BagIt checksum algorithm.
+	"crypto/sha1" // #nosec G505 -- sha1 is a valid BagIt checksum algorithm.
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"strings"
+)
+
+// A tag is a label and value pair from a tag file.
+type tag struct {
+	label string
+	value string
+}
+
+// tags is an ordered list of tags, labels can be repeated.
+type tags []tag
+
+// get returns the value of the first tag with the given label, matching the
+// label case-insensitively.
+func (t tags) get(label string) (string, bool) {
+	for _, tg := range t {
+		if strings.EqualFold(tg.label, label) {
+			return tg.value, true
+		}
+	}
+	return "", false
+}
+
+// values returns the values of all the tags with the given label, matching
+// the label case-insensitively.
+func (t tags) values(label string) []string {
+	var values []string
+	for _, tg := range t {
+		if strings.EqualFold(tg.label, label) {
+			values = append(values, tg.value)
+		}
+	}
+	return values
+}
+
+// parseTags parses the content of a tag file with "Label: value" lines, where
+// lines starting with whitespace continue the value of the previous tag.
+func parseTags(b []byte) (tags, error) {
+	var t tags
+	s := bufio.NewScanner(bytes.NewReader(bytes.TrimPrefix(b, []byte("\ufeff"))))
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(t) == 0 {
+				return nil, fmt.Errorf("unexpected continuation line %q", line)
+			}
+			t[len(t)-1].value += " " + strings.TrimSpace(line)
+			continue
+		}
+
+		label, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(label) == "" {
+			return nil, fmt.Errorf("invalid tag line %q", line)
+		}
+		t = append(t, tag{label: strings.TrimSpace(label), value: strings.TrimSpace(value)})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// manifest is a BagIt manifest file.
+type manifest struct {
+	// name is the manifest file name, e.g. "manifest-sha512.txt".
+	name string
+
+	// alg is the manifest checksum algorithm.
+	alg string
+
+	// entries maps Bag relative file paths to their checksums.
+	entries map[string]string
+}
+
+// manifestAlgorithm returns the checksum algorithm of a manifest file name
+// with the given prefix ("manifest" or "tagmanifest"), and false if the name
+// is not a manifest name.
+func manifestAlgorithm(prefix, name string) (string, bool) {
+	alg, ok := strings.CutPrefix(name, prefix+"-")
+	if !ok {
+		return "", false
+	}
+	alg, ok = strings.CutSuffix(alg, ".txt")
+	if !ok || alg == "" {
+		return "", false
+	}
+
+	return alg, true
+}
+
+// parseManifest parses the content of a manifest file with "<checksum> <path>"
+// lines. Paths are percent-decoded for BagIt 1.0 Bags.
+func parseManifest(name, alg string, b []byte, decode bool) (*manifest, error) {
+	m := &manifest{name: name, alg: alg, entries: make(map[string]string)}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		line = strings.TrimSpace(line)
+		i := strings.IndexAny(line, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf("malformed line %q", line)
+		}
+		checksum, p := line[:i], strings.TrimSpace(line[i:])
+		if decode {
+			p = decodePath(p)
+		}
+
+		if !safePath(p) {
+			return nil, fmt.Errorf("unsafe path %q", p)
+		}
+		m.entries[path.Clean(p)] = strings.ToLower(checksum)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// decodePath decodes the percent-encoded characters allowed in BagIt 1.0
+// manifest and fetch file paths.
+func decodePath(p string) string {
+	return strings.NewReplacer("%0A", "\n", "%0a", "\n", "%0D", "\r", "%0d", "\r", "%25", "%").Replace(p)
+}
+
+// safePath returns true if p is a relative path that doesn't escape the Bag.
+func safePath(p string) bool {
+	if p == "" || path.IsAbs(p) || strings.HasPrefix(p, `\`) {
+		return false
+	}
+	c := path.Clean(p)
+
+	return c != ".." && !strings.HasPrefix(c, "../")
+}
+
+// newHash returns a new hash.Hash for the given checksum algorithm, or nil if
+// the algorithm is not supported.
+func newHash(alg string) hash.Hash {
+	switch alg {
+	case "md5":
+		return md5.New() // #nosec G401
+	case "sha1":
+		return sha1.New() // #nosec G401
+	case "sha224":
+		return sha256.New224()
+	case "sha256":
+		return sha256.New()
+	case "sha384":
+		return sha512.New384()
+	case "sha512":
+		return sha512.New()
+	default:
+		return nil
+	}
+}
+
+// multiHash is an io.Writer generating the checksums of the written data for
+// several algorithms at once.
+type multiHash struct {
+	io.Writer
+	hashes map[string]hash.Hash
+}
+
+// newMultiHash returns a multiHash for the given supported algorithms.
+func newMultiHash(algs []string) *multiHash {
+	mh := &multiHash{hashes: make(map[string]hash.Hash, len(algs))}
+	writers := make([]io.Writer, 0, len(algs))
+	for _, alg := range algs {
+		h := newHash(alg)
+		mh.hashes[alg] = h
+		writers = append(writers, h)
+	}
+	mh.Writer = io.MultiWriter(writers...)
+
+	return mh
+}
+
+// sums returns the hex encoded checksums by algorithm.
+func (mh *multiHash) sums() map[string]string {
+	sums := make(map[string]string, len(mh.hashes))
+	for alg, h := range mh.hashes {
+		sums[alg] = hex.EncodeToString(h.Sum(nil))
+	}
+
+	return sums
+}
@@ -1,17 +1,141 @@
 package bagvalidate
 
+import (
+	"context"
+	"fmt"
+)
+
+// Mode is the validation mode used to validate a Bag.
+type Mode string
+
+const (
+	// ModeFull validates the Bag structure, completeness, Payload-Oxum and
+	// the checksums of all the files listed in the manifests. It's the
+	// default mode.
+	ModeFull Mode = "full"
+
+	// ModeComplete validates the Bag structure, Payload-Oxum and that all
+	// the files listed in the manifests exist, without verifying their
+	// checksums.
+	ModeComplete Mode = "complete"
+
+	// ModeOxum validates the Bag structure and that the Payload-Oxum matches
+	// the payload files. It requires a Payload-Oxum in bag-info.txt.
+	ModeOxum Mode = "oxum"
+)
+
+// modes lists the valid validation modes.
+var modes = []Mode{ModeFull, ModeComplete, ModeOxum}
+
+// String implements fmt.Stringer, returning ModeFull for the zero value.
+func (m Mode) String() string {
+	if m == "" {
+		return string(ModeFull)
+	}
+	return string(m)
+}
+
+// validate returns an error if m is not a valid validation mode.
+func (m Mode) validate() error {
+	for _, mode := range modes {
+		if m.String() == string(mode) {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid value %q, must be one of (full, complete, oxum)", string(m))
+}
+
 type BagValidator interface {
 	Validate(path string) error
 }
 
+// ModeValidator is a BagValidator that supports validation modes other than
+// ModeFull. Validators that don't implement it can only be used with
+// ModeFull.
+type ModeValidator interface {
+	BagValidator
+	ValidateMode(path string, mode Mode) error
+}
+
+// Progress is the progress of a Bag validation.
+type Progress struct {
+	// Files is the number of files verified.
+	Files int
+
+	// Bytes is the number of bytes hashed.
+	Bytes int64
+}
+
+// ContextValidator is a validator that stops when ctx is cancelled and
+// reports the validation progress to the progress function, if not nil.
+type ContextValidator interface {
+	ValidateContext(ctx context.Context, path string, mode Mode, progress func(Progress)) error
+}
+
+// NewContextValidator returns v if it implements ContextValidator, otherwise
+// it returns a ContextValidator that runs v in a goroutine and stops waiting
+// for it when ctx is cancelled. Cancelling ctx doesn't stop v: the validation
+// keeps running in the background, with any subprocess it uses, until it
+// finishes. The adapted validator doesn't report progress and only supports
+// modes other than ModeFull if v implements ModeValidator.
+func NewContextValidator(v BagValidator) ContextValidator {
+	if cv, ok := v.(ContextValidator); ok {
+		return cv
+	}
+	return contextAdapter{v: v}
+}
+
+// contextAdapter adapts a BagValidator to the ContextValidator interface.
+type contextAdapter struct {
+	v BagValidator
+}
+
+func (a contextAdapter) ValidateContext(ctx context.Context, path string, mode Mode, _ func(Progress)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// The validation result is ignored if ctx is cancelled first, so the
+	// channel is buffered to let the goroutine finish. The validation can't be
+	// interrupted and keeps running until then.
+	done := make(chan error, 1)
+	go func() {
+		done <- a.validate(path, mode)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// validate validates the Bag at path with the given mode, using ValidateMode
+// if the adapted validator supports it.
+func (a contextAdapter) validate(path string, mode Mode) error {
+	if v, ok := a.v.(ModeValidator); ok {
+		return v.ValidateMode(path, mode)
+	}
+	if mode.String() != string(ModeFull) {
+		return fmt.Errorf("validator doesn't support %q mode", mode)
+	}
+
+	return a.v.Validate(path)
+}
+
 type noopValidator struct{}
 
 func (v noopValidator) Validate(path string) error {
 	return nil
 }
 
+func (v noopValidator) ValidateMode(path string, mode Mode) error {
+	return nil
+}
+
 func NewNoopValidator() noopValidator {
 	return noopValidator{}
 }
 
-var _ BagValidator = noopValidator{}
+var _ ModeValidator = noopValidator{}
@@ -1,7 +1,9 @@
 package bagvalidate_test
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"gotest.tools/v3/assert"
 
@@ -12,3 +14,54 @@ func TestNoopValidator(t *testing.T) {
 	v := bagvalidate.NewNoopValidator()
 	assert.NilError(t, v.Validate(""))
 }
+
+// blockingValidator is a BagValidator that blocks until it's released.
+type blockingValidator struct {
+	release chan struct{}
+}
+
+func (v blockingValidator) Validate(string) error {
+	<-v.release
+	return nil
+}
+
+func TestContextValidator(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Returns a ContextValidator unchanged", func(t *testing.T) {
+		t.Parallel()
+
+		v := bagvalidate.NewNativeValidator()
+		assert.Equal(t, bagvalidate.NewContextValidator(v), bagvalidate.ContextValidator(v))
+	})
+
+	t.Run("Validates with an adapted validator", func(t *testing.T) {
+		t.Parallel()
+
+		v := bagvalidate.NewContextValidator(bagvalidate.NewMockValidator().SetErr(bagvalidate.ErrInvalid))
+		err := v.ValidateContext(context.Background(), "", bagvalidate.ModeComplete, nil)
+		assert.ErrorIs(t, err, bagvalidate.ErrInvalid)
+	})
+
+	t.Run("Errors if the adapted validator doesn't support the mode", func(t *testing.T) {
+		t.Parallel()
+
+		v := bagvalidate.NewContextValidator(blockingValidator{})
+		err := v.ValidateContext(context.Background(), "", bagvalidate.ModeOxum, nil)
+		assert.Error(t, err, `validator doesn't support "oxum" mode`)
+	})
+
+	t.Run("Stops waiting when the context is cancelled", func(t *testing.T) {
+		t.Parallel()
+
+		release := make(chan struct{})
+		t.Cleanup(func() { close(release) })
+
+		ctx, cancel := context.WithCancel(context.Background())
+		v := bagvalidate.NewContextValidator(blockingValidator{release: release})
+		time.AfterFunc(10*time.Millisecond, cancel)
+
+		err := v.ValidateContext(ctx, "", bagvalidate.ModeFull, nil)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
@@ -2,10 +2,15 @@ package bucketcopy
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"strings"
 
 	"go.artefactual.dev/tools/temporal"
 	"gocloud.dev/blob"
+
+	"github.com/artefactual-sdps/temporal-activities/internal/heartbeat"
 )
 
 const Name = "bucket-copy"
@@ -17,24 +22,110 @@ type (
 
 		// Destination object key.
 		DestKey string
+
+		// SourcePrefix enables the prefix mode, copying all the objects with
+		// keys starting with SourcePrefix to keys starting with DestPrefix
+		// instead. SourceKey and DestKey are ignored in prefix mode. Neither
+		// prefix can start with the other for in-bucket copies.
+		SourcePrefix string
+		DestPrefix   string
+
+		// Move deletes the source objects after they are copied and
+		// verified.
+		Move bool
+
+		// Concurrency is the maximum number of objects copied at once in
+		// prefix mode, default: 4.
+		Concurrency int
+
+		// ContentType replaces the content type of the copied objects if
+		// set. The source content type is kept otherwise.
+		ContentType string
+
+		// ReplaceMetadata replaces the metadata of the copied objects with
+		// Metadata, removing it if Metadata is empty. The source metadata is
+		// kept otherwise.
+		ReplaceMetadata bool
+		Metadata        map[string]string
+	}
+	Result struct {
+		// Size is the size in bytes of the copied object, or the total size
+		// of the copied objects in prefix mode.
+		Size int64
+
+		// MD5 is the hex encoded MD5 checksum of the copied object. It may be
+		// empty for in-bucket copies if the provider doesn't return it, and
+		// it's empty in prefix mode.
+		MD5 string
+
+		// Objects lists the copied objects, sorted by source key, in prefix
+		// mode.
+		Objects []Object
 	}
-	Result   struct{}
 	Activity struct {
-		bucket *blob.Bucket
+		source *blob.Bucket
+		dest   *blob.Bucket
 	}
 )
 
+// New returns an activity that copies objects within bucket.
 func New(bucket *blob.Bucket) *Activity {
-	return &Activity{bucket: bucket}
+	return &Activity{source: bucket, dest: bucket}
+}
+
+// NewCrossBucket returns an activity that copies objects from the source
+// bucket to the dest bucket, which may use different providers. If source and
+// dest are the same bucket, the objects are copied within the bucket.
+func NewCrossBucket(source, dest *blob.Bucket) *Activity {
+	return &Activity{source: source, dest: dest}
 }
 
 func (a *Activity) Execute(ctx context.Context, params *Params) (*Result, error) {
-	h := temporal.StartAutoHeartbeat(ctx)
-	defer h.Stop()
+	if params.SourcePrefix != "" {
+		// Overlapping prefixes would copy the copied objects again.
+		if a.source == a.dest &&
+			(strings.HasPrefix(params.SourcePrefix, params.DestPrefix) ||
+				strings.HasPrefix(params.DestPrefix, params.SourcePrefix)) {
+			return nil, errors.New("bucketcopy: DestPrefix: must not overlap with SourcePrefix")
+		}
 
-	if err := a.bucket.Copy(ctx, params.DestKey, params.SourceKey, nil); err != nil {
-		return nil, fmt.Errorf("bucketcopy: copy blob: %w", err)
+		objects, size, err := a.copyPrefix(ctx, params)
+		if err != nil {
+			return nil, fmt.Errorf("bucketcopy: %w", err)
+		}
+
+		return &Result{Size: size, Objects: objects}, nil
+	}
+
+	if a.source == a.dest && params.SourceKey == params.DestKey && params.Move {
+		return nil, errors.New("bucketcopy: DestKey: must be different from SourceKey")
 	}
 
-	return &Result{}, nil
+	var h *heartbeat.Heartbeat[Progress]
+	if a.source != a.dest || params.overridesAttributes() {
+		h = heartbeat.Start[Progress](ctx)
+		defer h.Stop()
+	} else {
+		ah := temporal.StartAutoHeartbeat(ctx)
+		defer ah.Stop()
+	}
+
+	size, sum, err := a.copyObject(ctx, params.DestKey, params.SourceKey, params, h)
+	if err != nil {
+		return nil, fmt.Errorf("bucketcopy: %w", err)
+	}
+
+	if params.Move {
+		if err := a.source.Delete(ctx, params.SourceKey); err != nil {
+			return nil, fmt.Errorf("bucketcopy: delete source: %w", err)
+		}
+	}
+
+	return &Result{Size: size, MD5: hex.EncodeToString(sum)}, nil
+}
+
+// overridesAttributes returns true if params overrides the attributes of the
+// copied objects.
+func (p *Params) overridesAttributes() bool {
+	return p.ContentType != "" || p.ReplaceMetadata
 }
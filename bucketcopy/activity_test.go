@@ -1,13 +1,18 @@
 package bucketcopy_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"io"
 	"testing"
 
 	temporalsdk_activity "go.temporal.io/sdk/activity"
 	temporalsdk_testsuite "go.temporal.io/sdk/testsuite"
 	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
 	"gocloud.dev/blob/memblob"
+	"gocloud.dev/gcerrors"
 	"gotest.tools/v3/assert"
 
 	"github.com/artefactual-sdps/temporal-activities/bucketcopy"
@@ -82,3 +87,573 @@ func TestActivity(t *testing.T) {
 		})
 	}
 }
+
+func TestActivityCrossBucket(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		name    string
+		source  *blob.Bucket
+		params  bucketcopy.Params
+		wantRes bucketcopy.Result
+		wantErr string
+	}
+	for _, tt := range []test{
+		{
+			name:   "Copies a blob to another bucket",
+			source: bucket(t, "source.txt", "content"),
+			params: bucketcopy.Params{
+				SourceKey: "source.txt",
+				DestKey:   "dest.txt",
+			},
+			wantRes: bucketcopy.Result{Size: 7, MD5: "9a0364b9e99bb480dd25e1f0284c8555"},
+		},
+		{
+			name:   "Fails copying a missing blob to another bucket",
+			source: bucket(t, "", ""),
+			params: bucketcopy.Params{
+				SourceKey: "missing.txt",
+				DestKey:   "dest.txt",
+			},
+			wantErr: "bucketcopy: copy blob:",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if tt.wantErr == "" {
+				err := tt.source.WriteAll(context.Background(), tt.params.SourceKey, []byte("content"), &blob.WriterOptions{
+					ContentType: "text/plain",
+					Metadata:    map[string]string{"package-id": "1234"},
+				})
+				assert.NilError(t, err)
+			}
+			dest := bucket(t, "", "")
+
+			ts := &temporalsdk_testsuite.WorkflowTestSuite{}
+			env := ts.NewTestActivityEnvironment()
+			env.RegisterActivityWithOptions(
+				bucketcopy.NewCrossBucket(tt.source, dest).Execute,
+				temporalsdk_activity.RegisterOptions{Name: bucketcopy.Name},
+			)
+
+			enc, err := env.ExecuteActivity(bucketcopy.Name, tt.params)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+
+			var result bucketcopy.Result
+			_ = enc.Get(&result)
+			assert.DeepEqual(t, result, tt.wantRes)
+
+			destData, err := dest.ReadAll(context.Background(), tt.params.DestKey)
+			assert.NilError(t, err)
+			assert.Equal(t, string(destData), "content")
+
+			attrs, err := dest.Attributes(context.Background(), tt.params.DestKey)
+			assert.NilError(t, err)
+			assert.Equal(t, attrs.ContentType, "text/plain")
+			assert.DeepEqual(t, attrs.Metadata, map[string]string{"package-id": "1234"})
+
+			// The source key is not created in the destination bucket.
+			exists, err := dest.Exists(context.Background(), tt.params.SourceKey)
+			assert.NilError(t, err)
+			assert.Assert(t, !exists)
+		})
+	}
+}
+
+// failingBucket is a bucket driver with a single object, whose readers fail
+// after reading half of its content.
+type failingBucket struct {
+	driver.Bucket
+	content []byte
+}
+
+func (b *failingBucket) Attributes(context.Context, string) (*driver.Attributes, error) {
+	return &driver.Attributes{ContentType: "text/plain", Size: int64(len(b.content))}, nil
+}
+
+func (b *failingBucket) NewRangeReader(
+	context.Context,
+	string,
+	int64,
+	int64,
+	*driver.ReaderOptions,
+) (driver.Reader, error) {
+	return &failingReader{
+		r:    bytes.NewReader(b.content[:len(b.content)/2]),
+		size: int64(len(b.content)),
+	}, nil
+}
+
+func (b *failingBucket) ErrorCode(error) gcerrors.ErrorCode { return gcerrors.Unknown }
+
+func (b *failingBucket) Close() error { return nil }
+
+type failingReader struct {
+	r    *bytes.Reader
+	size int64
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		err = errors.New("connection reset")
+	}
+
+	return n, err
+}
+
+func (r *failingReader) Close() error { return nil }
+
+func (r *failingReader) Attributes() *driver.ReaderAttributes {
+	return &driver.ReaderAttributes{ContentType: "text/plain", Size: r.size}
+}
+
+func (r *failingReader) As(any) bool { return false }
+
+func TestActivityFailedCopy(t *testing.T) {
+	t.Parallel()
+
+	source := blob.NewBucket(&failingBucket{content: []byte("content")})
+	t.Cleanup(func() { source.Close() })
+	dest := bucket(t, "", "")
+
+	ts := &temporalsdk_testsuite.WorkflowTestSuite{}
+	env := ts.NewTestActivityEnvironment()
+	env.RegisterActivityWithOptions(
+		bucketcopy.NewCrossBucket(source, dest).Execute,
+		temporalsdk_activity.RegisterOptions{Name: bucketcopy.Name},
+	)
+
+	_, err := env.ExecuteActivity(bucketcopy.Name, bucketcopy.Params{
+		SourceKey: "source.txt",
+		DestKey:   "dest.txt",
+	})
+	assert.ErrorContains(t, err, "bucketcopy: copy blob:")
+
+	// The truncated copy is discarded.
+	exists, err := dest.Exists(context.Background(), "dest.txt")
+	assert.NilError(t, err)
+	assert.Assert(t, !exists)
+}
+
+func TestActivityPrefix(t *testing.T) {
+	t.Parallel()
+
+	const contentMD5 = "9a0364b9e99bb480dd25e1f0284c8555"
+
+	type test struct {
+		name        string
+		cross       bool
+		params      bucketcopy.Params
+		copied      []string
+		moved       []string
+		details     *bucketcopy.Progress
+		wantSize    int64
+		wantObjects []bucketcopy.Object
+		wantSource  []string
+		wantDest    []string
+		wantErr     string
+	}
+	for _, tt := range []test{
+		{
+			name:     "Copies a prefix within a bucket",
+			params:   bucketcopy.Params{SourcePrefix: "incoming/", DestPrefix: "processed/"},
+			wantSize: 14,
+			wantObjects: []bucketcopy.Object{
+				{SourceKey: "incoming/a.txt", DestKey: "processed/a.txt", Size: 7, MD5: contentMD5},
+				{SourceKey: "incoming/sub/b.txt", DestKey: "processed/sub/b.txt", Size: 7, MD5: contentMD5},
+			},
+			wantSource: []string{
+				"incoming/a.txt",
+				"incoming/sub/b.txt",
+				"other/c.txt",
+				"processed/a.txt",
+				"processed/sub/b.txt",
+			},
+			wantDest: []string{
+				"incoming/a.txt",
+				"incoming/sub/b.txt",
+				"other/c.txt",
+				"processed/a.txt",
+				"processed/sub/b.txt",
+			},
+		},
+		{
+			name:     "Moves a prefix to another bucket",
+			cross:    true,
+			params:   bucketcopy.Params{SourcePrefix: "incoming/", DestPrefix: "processed/", Move: true, Concurrency: 1},
+			wantSize: 14,
+			wantObjects: []bucketcopy.Object{
+				{SourceKey: "incoming/a.txt", DestKey: "processed/a.txt", Size: 7, MD5: contentMD5, Deleted: true},
+				{
+					SourceKey: "incoming/sub/b.txt",
+					DestKey:   "processed/sub/b.txt",
+					Size:      7,
+					MD5:       contentMD5,
+					Deleted:   true,
+				},
+			},
+			wantSource: []string{"other/c.txt"},
+			wantDest:   []string{"processed/a.txt", "processed/sub/b.txt"},
+		},
+		{
+			name:   "Resumes a prefix copy",
+			cross:  true,
+			params: bucketcopy.Params{SourcePrefix: "incoming/", DestPrefix: "processed/"},
+			copied: []string{"processed/a.txt"},
+			details: &bucketcopy.Progress{
+				SourcePrefix:  "incoming/",
+				DestPrefix:    "processed/",
+				Cursor:        "incoming/a.txt",
+				CopiedObjects: 1,
+				CopiedSize:    7,
+			},
+			wantSize: 14,
+			wantObjects: []bucketcopy.Object{
+				{SourceKey: "incoming/a.txt", DestKey: "processed/a.txt", Size: 7, MD5: contentMD5},
+				{SourceKey: "incoming/sub/b.txt", DestKey: "processed/sub/b.txt", Size: 7, MD5: contentMD5},
+			},
+			wantSource: []string{"incoming/a.txt", "incoming/sub/b.txt", "other/c.txt"},
+			wantDest:   []string{"processed/a.txt", "processed/sub/b.txt"},
+		},
+		{
+			name:   "Resumes a prefix move",
+			cross:  true,
+			params: bucketcopy.Params{SourcePrefix: "incoming/", DestPrefix: "processed/", Move: true},
+			copied: []string{"processed/a.txt"},
+			details: &bucketcopy.Progress{
+				SourcePrefix:  "incoming/",
+				DestPrefix:    "processed/",
+				Cursor:        "incoming/a.txt",
+				CopiedObjects: 1,
+				CopiedSize:    7,
+			},
+			wantSize: 14,
+			wantObjects: []bucketcopy.Object{
+				{SourceKey: "incoming/a.txt", DestKey: "processed/a.txt", Size: 7, MD5: contentMD5, Deleted: true},
+				{
+					SourceKey: "incoming/sub/b.txt",
+					DestKey:   "processed/sub/b.txt",
+					Size:      7,
+					MD5:       contentMD5,
+					Deleted:   true,
+				},
+			},
+			// The source object left by the previous attempt is copied again.
+			wantSource: []string{"other/c.txt"},
+			wantDest:   []string{"processed/a.txt", "processed/sub/b.txt"},
+		},
+		{
+			name:   "Resumes a prefix move without the last cursor",
+			cross:  true,
+			params: bucketcopy.Params{SourcePrefix: "incoming/", DestPrefix: "processed/", Move: true},
+			copied: []string{"processed/a.txt"},
+			moved:  []string{"incoming/a.txt"},
+			details: &bucketcopy.Progress{
+				SourcePrefix: "incoming/",
+				DestPrefix:   "processed/",
+			},
+			wantSize: 7,
+			wantObjects: []bucketcopy.Object{
+				{
+					SourceKey: "incoming/sub/b.txt",
+					DestKey:   "processed/sub/b.txt",
+					Size:      7,
+					MD5:       contentMD5,
+					Deleted:   true,
+				},
+			},
+			wantSource: []string{"other/c.txt"},
+			wantDest:   []string{"processed/a.txt", "processed/sub/b.txt"},
+		},
+		{
+			name:   "Ignores the progress of a different prefix copy",
+			cross:  true,
+			params: bucketcopy.Params{SourcePrefix: "incoming/", DestPrefix: "processed/"},
+			details: &bucketcopy.Progress{
+				SourcePrefix:  "incoming/",
+				DestPrefix:    "failed/",
+				Cursor:        "incoming/a.txt",
+				CopiedObjects: 1,
+				CopiedSize:    7,
+			},
+			wantSize: 14,
+			wantObjects: []bucketcopy.Object{
+				{SourceKey: "incoming/a.txt", DestKey: "processed/a.txt", Size: 7, MD5: contentMD5},
+				{SourceKey: "incoming/sub/b.txt", DestKey: "processed/sub/b.txt", Size: 7, MD5: contentMD5},
+			},
+			wantSource: []string{"incoming/a.txt", "incoming/sub/b.txt", "other/c.txt"},
+			wantDest:   []string{"processed/a.txt", "processed/sub/b.txt"},
+		},
+		{
+			name:    "Fails to move a prefix onto itself",
+			params:  bucketcopy.Params{SourcePrefix: "incoming/", DestPrefix: "incoming/", Move: true},
+			wantErr: "bucketcopy: DestPrefix: must not overlap with SourcePrefix",
+		},
+		{
+			name:    "Fails to copy a prefix into itself",
+			params:  bucketcopy.Params{SourcePrefix: "incoming/", DestPrefix: "incoming/copy/"},
+			wantErr: "bucketcopy: DestPrefix: must not overlap with SourcePrefix",
+		},
+		{
+			name:    "Fails to copy a prefix into its parent",
+			params:  bucketcopy.Params{SourcePrefix: "incoming/sub/", DestPrefix: "incoming/"},
+			wantErr: "bucketcopy: DestPrefix: must not overlap with SourcePrefix",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			source := bucket(t, "other/c.txt", "content")
+			for _, key := range []string{"incoming/a.txt", "incoming/sub/b.txt"} {
+				assert.NilError(t, source.WriteAll(context.Background(), key, []byte("content"), nil))
+			}
+			dest := source
+			if tt.cross {
+				dest = bucket(t, "", "")
+			}
+			for _, key := range tt.copied {
+				assert.NilError(t, dest.WriteAll(context.Background(), key, []byte("content"), nil))
+			}
+			for _, key := range tt.moved {
+				assert.NilError(t, source.Delete(context.Background(), key))
+			}
+
+			ts := &temporalsdk_testsuite.WorkflowTestSuite{}
+			env := ts.NewTestActivityEnvironment()
+			if tt.details != nil {
+				env.SetHeartbeatDetails(tt.details)
+			}
+			env.RegisterActivityWithOptions(
+				bucketcopy.NewCrossBucket(source, dest).Execute,
+				temporalsdk_activity.RegisterOptions{Name: bucketcopy.Name},
+			)
+
+			enc, err := env.ExecuteActivity(bucketcopy.Name, tt.params)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+
+			var result bucketcopy.Result
+			_ = enc.Get(&result)
+			assert.DeepEqual(t, result, bucketcopy.Result{Size: tt.wantSize, Objects: tt.wantObjects})
+			assert.DeepEqual(t, keys(t, source), tt.wantSource)
+			assert.DeepEqual(t, keys(t, dest), tt.wantDest)
+		})
+	}
+}
+
+func keys(t *testing.T, b *blob.Bucket) []string {
+	t.Helper()
+
+	var keys []string
+	iter := b.List(nil)
+	for {
+		obj, err := iter.Next(context.Background())
+		if err == io.EOF {
+			break
+		}
+		assert.NilError(t, err)
+		keys = append(keys, obj.Key)
+	}
+
+	return keys
+}
+
+// attrsBucket is a bucket driver backed by a bucket, that drops the object
+// attributes on copies or the metadata on writes.
+type attrsBucket struct {
+	driver.Bucket
+	b           *blob.Bucket
+	dropOnCopy  bool
+	dropOnWrite bool
+}
+
+func (b *attrsBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	attrs, err := b.b.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &driver.Attributes{
+		ContentType: attrs.ContentType,
+		Metadata:    attrs.Metadata,
+		ModTime:     attrs.ModTime,
+		Size:        attrs.Size,
+		MD5:         attrs.MD5,
+	}, nil
+}
+
+func (b *attrsBucket) NewRangeReader(
+	ctx context.Context,
+	key string,
+	offset, length int64,
+	_ *driver.ReaderOptions,
+) (driver.Reader, error) {
+	r, err := b.b.NewRangeReader(ctx, key, offset, length, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &attrsReader{r}, nil
+}
+
+func (b *attrsBucket) NewTypedWriter(
+	ctx context.Context,
+	key, contentType string,
+	opts *driver.WriterOptions,
+) (driver.Writer, error) {
+	wopts := &blob.WriterOptions{ContentType: contentType, ContentMD5: opts.ContentMD5, Metadata: opts.Metadata}
+	if b.dropOnWrite {
+		wopts.Metadata = nil
+	}
+
+	return b.b.NewWriter(ctx, key, wopts)
+}
+
+func (b *attrsBucket) Copy(ctx context.Context, dstKey, srcKey string, _ *driver.CopyOptions) error {
+	if !b.dropOnCopy {
+		return b.b.Copy(ctx, dstKey, srcKey, nil)
+	}
+
+	content, err := b.b.ReadAll(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+
+	return b.b.WriteAll(ctx, dstKey, content, &blob.WriterOptions{ContentType: "application/octet-stream"})
+}
+
+func (b *attrsBucket) ErrorCode(err error) gcerrors.ErrorCode { return gcerrors.Code(err) }
+
+func (b *attrsBucket) Close() error { return nil }
+
+type attrsReader struct {
+	*blob.Reader
+}
+
+func (r *attrsReader) Attributes() *driver.ReaderAttributes {
+	return &driver.ReaderAttributes{ContentType: r.ContentType(), ModTime: r.ModTime(), Size: r.Size()}
+}
+
+func TestActivityAttributes(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		name        string
+		cross       bool
+		dropOnCopy  bool
+		dropOnWrite bool
+		params      bucketcopy.Params
+		wantAttrs   blob.Attributes
+		wantErr     string
+	}
+	for _, tt := range []test{
+		{
+			name: "Keeps the source attributes",
+			wantAttrs: blob.Attributes{
+				ContentType: "text/plain",
+				Metadata:    map[string]string{"package-id": "1234"},
+			},
+		},
+		{
+			name:   "Replaces the content type",
+			params: bucketcopy.Params{ContentType: "application/xml"},
+			wantAttrs: blob.Attributes{
+				ContentType: "application/xml",
+				Metadata:    map[string]string{"package-id": "1234"},
+			},
+		},
+		{
+			name:  "Replaces the metadata in another bucket",
+			cross: true,
+			params: bucketcopy.Params{
+				ReplaceMetadata: true,
+				Metadata:        map[string]string{"status": "preserved"},
+			},
+			wantAttrs: blob.Attributes{
+				ContentType: "text/plain",
+				Metadata:    map[string]string{"status": "preserved"},
+			},
+		},
+		{
+			name:      "Removes the metadata",
+			params:    bucketcopy.Params{ReplaceMetadata: true},
+			wantAttrs: blob.Attributes{ContentType: "text/plain"},
+		},
+		{
+			name:       "Streams the blob if the copy doesn't keep the attributes",
+			dropOnCopy: true,
+			wantAttrs: blob.Attributes{
+				ContentType: "text/plain",
+				Metadata:    map[string]string{"package-id": "1234"},
+			},
+		},
+		{
+			name:        "Fails if the copied blob doesn't have the metadata",
+			cross:       true,
+			dropOnWrite: true,
+			wantErr:     "bucketcopy: verify copy: metadata mismatch: expected map[package-id:1234], got map[]",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			source := bucket(t, "", "")
+			err := source.WriteAll(context.Background(), "source.txt", []byte("content"), &blob.WriterOptions{
+				ContentType: "text/plain",
+				Metadata:    map[string]string{"package-id": "1234"},
+			})
+			assert.NilError(t, err)
+			if tt.dropOnCopy {
+				source = blob.NewBucket(&attrsBucket{b: source, dropOnCopy: true})
+			}
+			dest := source
+			if tt.cross {
+				dest = bucket(t, "", "")
+			}
+			if tt.dropOnWrite {
+				dest = blob.NewBucket(&attrsBucket{b: dest, dropOnWrite: true})
+			}
+
+			ts := &temporalsdk_testsuite.WorkflowTestSuite{}
+			env := ts.NewTestActivityEnvironment()
+			env.RegisterActivityWithOptions(
+				bucketcopy.NewCrossBucket(source, dest).Execute,
+				temporalsdk_activity.RegisterOptions{Name: bucketcopy.Name},
+			)
+
+			params := tt.params
+			params.SourceKey = "source.txt"
+			params.DestKey = "dest.txt"
+			enc, err := env.ExecuteActivity(bucketcopy.Name, params)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+
+			var result bucketcopy.Result
+			_ = enc.Get(&result)
+			assert.DeepEqual(t, result, bucketcopy.Result{Size: 7, MD5: "9a0364b9e99bb480dd25e1f0284c8555"})
+
+			attrs, err := dest.Attributes(context.Background(), "dest.txt")
+			assert.NilError(t, err)
+			assert.Equal(t, attrs.ContentType, tt.wantAttrs.ContentType)
+			assert.DeepEqual(t, attrs.Metadata, tt.wantAttrs.Metadata)
+
+			// The source attributes are not changed.
+			attrs, err = source.Attributes(context.Background(), "source.txt")
+			assert.NilError(t, err)
+			assert.Equal(t, attrs.ContentType, "text/plain")
+			assert.DeepEqual(t, attrs.Metadata, map[string]string{"package-id": "1234"})
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package bucketcopy
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+
+	temporalsdk_activity "go.temporal.io/sdk/activity"
+	"gocloud.dev/blob"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/artefactual-sdps/temporal-activities/internal/heartbeat"
+)
+
+const defaultConcurrency = 4
+
+// Object is an object copied in prefix mode.
+type Object struct {
+	// SourceKey and DestKey of the copied object.
+	SourceKey string
+	DestKey   string
+
+	// Size is the size in bytes of the copied object.
+	Size int64
+
+	// MD5 is the hex encoded MD5 checksum of the copied object. It may be
+	// empty for in-bucket copies, or objects copied by a previous attempt,
+	// if the provider doesn't return it.
+	MD5 string
+
+	// Deleted is true if the source object was deleted after the copy.
+	Deleted bool
+}
+
+// copyPrefix copies the objects under the source prefix concurrently to the
+// destination prefix, deleting the source objects after a verified copy if
+// params.Move is true, and returns the copied objects and their total size.
+// The last key up to which all the objects were copied, in listing order, is
+// recorded in the heartbeat details and a retried copy resumes after it.
+func (a *Activity) copyPrefix(ctx context.Context, params *Params) ([]Object, int64, error) {
+	h := heartbeat.Start[Progress](ctx)
+	defer h.Stop()
+
+	p := resumeProgress(ctx, Progress{SourcePrefix: params.SourcePrefix, DestPrefix: params.DestPrefix})
+	h.Set(p)
+
+	objs, err := a.listObjects(ctx, params.SourcePrefix)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list objects: %w", err)
+	}
+
+	// A retried copy skips the objects up to the cursor, copied by a previous
+	// attempt. A retried move copies all the objects left in the source: the
+	// previous attempt deleted the ones it copied, but the SDK may not have
+	// sent the heartbeat with its last cursor.
+	cursor := p.Cursor
+	p.Cursor, p.CopiedObjects, p.CopiedSize = "", 0, 0
+	var objects []Object
+	for len(objs) > 0 && !params.Move && cursor != "" && objs[0].Key <= cursor {
+		o := objs[0]
+		objects = append(objects, Object{
+			SourceKey: o.Key,
+			DestKey:   destKey(params, o.Key),
+			Size:      o.Size,
+			MD5:       hex.EncodeToString(o.MD5),
+		})
+		p.Cursor = o.Key
+		p.CopiedObjects++
+		p.CopiedSize += o.Size
+		objs = objs[1:]
+	}
+	h.Set(p)
+
+	copied := make([]*Object, len(objs))
+	next := 0
+
+	concurrency := defaultConcurrency
+	if params.Concurrency > 0 {
+		concurrency = params.Concurrency
+	}
+
+	var mu sync.Mutex
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+	for i, obj := range objs {
+		g.Go(func() error {
+			dstKey := destKey(params, obj.Key)
+			size, sum, err := a.copyObject(gctx, dstKey, obj.Key, params, nil)
+			if err != nil {
+				return fmt.Errorf("%s: %w", obj.Key, err)
+			}
+
+			o := &Object{SourceKey: obj.Key, DestKey: dstKey, Size: size, MD5: hex.EncodeToString(sum)}
+			if params.Move {
+				if err := a.source.Delete(gctx, obj.Key); err != nil {
+					return fmt.Errorf("%s: delete source: %w", obj.Key, err)
+				}
+				o.Deleted = true
+			}
+
+			// Advance the cursor over the objects copied in listing order.
+			mu.Lock()
+			defer mu.Unlock()
+			copied[i] = o
+			advanced := false
+			for next < len(objs) && copied[next] != nil {
+				p.Cursor = objs[next].Key
+				p.CopiedObjects++
+				p.CopiedSize += copied[next].Size
+				next++
+				advanced = true
+			}
+			if advanced {
+				h.Update(p)
+			}
+
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, 0, err
+	}
+
+	for _, o := range copied {
+		objects = append(objects, *o)
+	}
+	slices.SortFunc(objects, func(a, b Object) int { return strings.Compare(a.SourceKey, b.SourceKey) })
+
+	return objects, p.CopiedSize, nil
+}
+
+// destKey returns the destination key of the source object at key.
+func destKey(params *Params, key string) string {
+	return params.DestPrefix + strings.TrimPrefix(key, params.SourcePrefix)
+}
+
+// resumeProgress returns the progress of a previous attempt of the same
+// prefix copy recorded in the heartbeat details, or p.
+func resumeProgress(ctx context.Context, p Progress) Progress {
+	if !temporalsdk_activity.HasHeartbeatDetails(ctx) {
+		return p
+	}
+
+	var prev Progress
+	if err := temporalsdk_activity.GetHeartbeatDetails(ctx, &prev); err != nil {
+		return p
+	}
+	if prev.SourcePrefix != p.SourcePrefix || prev.DestPrefix != p.DestPrefix {
+		return p
+	}
+
+	return prev
+}
+
+// listObjects returns the objects under prefix in the source bucket, in
+// listing order.
+func (a *Activity) listObjects(ctx context.Context, prefix string) ([]*blob.ListObject, error) {
+	var objs []*blob.ListObject
+	iter := a.source.List(&blob.ListOptions{Prefix: prefix})
+	for {
+		obj, err := iter.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !obj.IsDir {
+			objs = append(objs, obj)
+		}
+	}
+
+	return objs, nil
+}
@@ -0,0 +1,28 @@
+package bucketcopy
+
+// Progress is the progress of a copy, recorded in the activity heartbeat
+// details.
+type Progress struct {
+	// SourceKey of the object copied between buckets.
+	SourceKey string
+
+	// Size of the object copied between buckets in bytes.
+	Size int64
+
+	// Copied is the number of bytes of the object copied between buckets.
+	Copied int64
+
+	// SourcePrefix and DestPrefix of a prefix copy.
+	SourcePrefix string
+	DestPrefix   string
+
+	// Cursor is the last source key of a prefix copy, in listing order, up
+	// to which all the objects were copied. A retried copy, but not a move,
+	// resumes after it.
+	Cursor string
+
+	// CopiedObjects and CopiedSize are the number and total size in bytes
+	// of the objects of a prefix copy copied up to Cursor.
+	CopiedObjects int
+	CopiedSize    int64
+}
@@ -0,0 +1,214 @@
+package bucketcopy
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" // #nosec G501 -- MD5 is used to check the object integrity.
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"strings"
+
+	"gocloud.dev/blob"
+
+	"github.com/artefactual-sdps/temporal-activities/internal/heartbeat"
+)
+
+// copyObject copies the object at srcKey to dstKey and checks the copied
+// object, returning its size and MD5. The object is copied within the bucket
+// if the source and destination buckets are the same and params doesn't
+// override the object attributes, and streamed through the worker otherwise or
+// if the in-bucket copy doesn't keep the content type and metadata.
+func (a *Activity) copyObject(
+	ctx context.Context,
+	dstKey, srcKey string,
+	params *Params,
+	h *heartbeat.Heartbeat[Progress],
+) (int64, []byte, error) {
+	if a.source != a.dest || params.overridesAttributes() {
+		return a.streamCopy(ctx, dstKey, srcKey, params, h)
+	}
+
+	attrs, err := a.source.Attributes(ctx, srcKey)
+	if err != nil {
+		return 0, nil, fmt.Errorf("copy blob: %w", err)
+	}
+	if err := a.source.Copy(ctx, dstKey, srcKey, &blob.CopyOptions{}); err != nil {
+		return 0, nil, fmt.Errorf("copy blob: %w", err)
+	}
+
+	dstAttrs, err := a.dest.Attributes(ctx, dstKey)
+	if err != nil {
+		return 0, nil, fmt.Errorf("verify copy: %w", err)
+	}
+	// The generic copy options don't have any setting, the object is streamed
+	// instead if the driver doesn't keep the source attributes.
+	opts := writerOptions(attrs, params)
+	if checkAttributes(dstAttrs, opts) != nil {
+		return a.streamCopy(ctx, dstKey, srcKey, params, h)
+	}
+	if err := checkCopy(dstAttrs, attrs.Size, attrs.MD5, attrs.MD5, opts); err != nil {
+		return 0, nil, fmt.Errorf("verify copy: %w", err)
+	}
+
+	return attrs.Size, attrs.MD5, nil
+}
+
+// streamCopy copies the object at srcKey in the source bucket to dstKey in the
+// destination bucket through the worker, keeping the object attributes not
+// overridden by params, and checks the copied object. The progress is recorded
+// in the heartbeats if h is not nil. It returns the size and MD5 of the copied
+// object.
+func (a *Activity) streamCopy(
+	ctx context.Context,
+	dstKey, srcKey string,
+	params *Params,
+	h *heartbeat.Heartbeat[Progress],
+) (int64, []byte, error) {
+	attrs, err := a.source.Attributes(ctx, srcKey)
+	if err != nil {
+		return 0, nil, fmt.Errorf("copy blob: %w", err)
+	}
+
+	r, err := a.source.NewReader(ctx, srcKey, nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("copy blob: %w", err)
+	}
+	defer r.Close()
+
+	// Cancelling the writer context discards the object on Close, so a
+	// failed copy doesn't leave a truncated object.
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	opts := writerOptions(attrs, params)
+	w, err := a.dest.NewWriter(wctx, dstKey, opts)
+	if err != nil {
+		return 0, nil, fmt.Errorf("copy blob: %w", err)
+	}
+
+	p := Progress{SourceKey: srcKey, Size: attrs.Size}
+	if h != nil {
+		h.Update(p)
+	}
+
+	md5h := md5.New() // #nosec G401 -- MD5 is used to check the object integrity.
+	pw := &progressWriter{w: io.MultiWriter(w, md5h), p: &p, h: h}
+	if _, err := io.Copy(pw, r); err != nil {
+		cancel()
+		_ = w.Close()
+		return 0, nil, fmt.Errorf("copy blob: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return 0, nil, fmt.Errorf("copy blob: %w", err)
+	}
+	if h != nil {
+		h.Update(p)
+	}
+
+	sum := md5h.Sum(nil)
+	if err := a.verify(ctx, dstKey, attrs.Size, attrs.MD5, sum, opts); err != nil {
+		return 0, nil, fmt.Errorf("verify copy: %w", err)
+	}
+
+	return p.Copied, sum, nil
+}
+
+// writerOptions returns the options to write the copy of an object with
+// attrs, keeping its attributes unless params overrides them.
+func writerOptions(attrs *blob.Attributes, params *Params) *blob.WriterOptions {
+	opts := &blob.WriterOptions{
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentType:        attrs.ContentType,
+		ContentMD5:         attrs.MD5,
+		Metadata:           attrs.Metadata,
+	}
+	if params.ContentType != "" {
+		opts.ContentType = params.ContentType
+	}
+	if params.ReplaceMetadata {
+		opts.Metadata = params.Metadata
+	}
+
+	return opts
+}
+
+// verify checks the destination object at key with checkCopy.
+func (a *Activity) verify(
+	ctx context.Context,
+	key string,
+	size int64,
+	srcMD5, sum []byte,
+	opts *blob.WriterOptions,
+) error {
+	attrs, err := a.dest.Attributes(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	return checkCopy(attrs, size, srcMD5, sum, opts)
+}
+
+// checkCopy checks that the size of the destination object with attrs and the
+// MD5 of the copied content match the source object, that the MD5 of the
+// destination object matches the copied content, and that the object has the
+// content type and metadata it was written with. The MD5 checksums are only
+// checked if the providers return them.
+func checkCopy(attrs *blob.Attributes, size int64, srcMD5, sum []byte, opts *blob.WriterOptions) error {
+	if attrs.Size != size {
+		return fmt.Errorf("size mismatch: expected %d bytes, got %d bytes", size, attrs.Size)
+	}
+	if len(srcMD5) > 0 && !bytes.Equal(srcMD5, sum) {
+		return fmt.Errorf("MD5 mismatch: expected %s, got %s", hex.EncodeToString(srcMD5), hex.EncodeToString(sum))
+	}
+	if len(sum) > 0 && len(attrs.MD5) > 0 && !bytes.Equal(attrs.MD5, sum) {
+		return fmt.Errorf("MD5 mismatch: expected %s, got %s", hex.EncodeToString(sum), hex.EncodeToString(attrs.MD5))
+	}
+
+	return checkAttributes(attrs, opts)
+}
+
+// checkAttributes checks that the object with attrs has the content type and
+// metadata of opts. The metadata keys are compared ignoring case, because some
+// drivers lowercase them.
+func checkAttributes(attrs *blob.Attributes, opts *blob.WriterOptions) error {
+	if attrs.ContentType != opts.ContentType {
+		return fmt.Errorf("content type mismatch: expected %q, got %q", opts.ContentType, attrs.ContentType)
+	}
+	if !maps.Equal(lowerKeys(attrs.Metadata), lowerKeys(opts.Metadata)) {
+		return fmt.Errorf("metadata mismatch: expected %v, got %v", opts.Metadata, attrs.Metadata)
+	}
+
+	return nil
+}
+
+// lowerKeys returns a copy of m with lowercase keys.
+func lowerKeys(m map[string]string) map[string]string {
+	lm := make(map[string]string, len(m))
+	for k, v := range m {
+		lm[strings.ToLower(k)] = v
+	}
+
+	return lm
+}
+
+// progressWriter updates the copy progress with the bytes written to w.
+type progressWriter struct {
+	w io.Writer
+	p *Progress
+	h *heartbeat.Heartbeat[Progress]
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	pw.p.Copied += int64(n)
+	if pw.h != nil {
+		pw.h.Set(*pw.p)
+	}
+
+	return n, err
+}
@@ -2,37 +2,227 @@ package bucketdelete
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
 
 	"go.artefactual.dev/tools/temporal"
 	"gocloud.dev/blob"
 	"gocloud.dev/gcerrors"
+	"golang.org/x/sync/errgroup"
 )
 
-const Name = "bucket-delete"
+const (
+	Name               = "bucket-delete"
+	defaultConcurrency = 4
+)
 
 type (
 	Params struct {
+		// Key of the object to delete.
 		Key string
+
+		// Keys of other objects to delete.
+		Keys []string
+
+		// Prefix deletes all the objects under Prefix in the key hierarchy,
+		// a slash is added if it doesn't end with one (e.g. "pkg" deletes
+		// "pkg/file.txt" but not "pkg2/file.txt").
+		Prefix string
+
+		// DryRun only lists the objects that would be deleted, without
+		// deleting them.
+		DryRun bool
+
+		// Concurrency is the maximum number of objects deleted at once,
+		// default: 4.
+		Concurrency int
+	}
+	Result struct {
+		// Deleted is the number of objects deleted.
+		Deleted int
+
+		// NotFound is the number of given keys without an object.
+		NotFound int
+
+		// Keys lists the keys of the deleted objects, or of the objects that
+		// would be deleted if DryRun is true, sorted.
+		Keys []string
+
+		// Refused lists the keys of the objects the safeguards refuse to
+		// delete with the reasons (e.g. "a.txt: modified less than 1h0m0s
+		// ago"), sorted. Only set if DryRun is true, otherwise any refusal
+		// fails the activity.
+		Refused []string
 	}
-	Result   struct{}
 	Activity struct {
 		bucket *blob.Bucket
+		cfg    Config
 	}
 )
 
-func New(bucket *blob.Bucket) *Activity {
-	return &Activity{bucket: bucket}
+// New returns an activity that deletes objects from bucket, with the
+// safeguards configured by cfg.
+func New(bucket *blob.Bucket, cfg Config) *Activity {
+	return &Activity{bucket: bucket, cfg: cfg}
 }
 
 func (a *Activity) Execute(ctx context.Context, params *Params) (*Result, error) {
 	h := temporal.StartAutoHeartbeat(ctx)
 	defer h.Stop()
 
-	err := a.bucket.Delete(ctx, params.Key)
-	if err != nil && gcerrors.Code(err) != gcerrors.NotFound {
-		return nil, fmt.Errorf("bucketdelete: delete key: %w", err)
+	keys, err := a.keys(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("bucketdelete: list objects: %w", err)
+	}
+
+	concurrency := defaultConcurrency
+	if params.Concurrency > 0 {
+		concurrency = params.Concurrency
+	}
+
+	// Check all the objects before deleting any of them.
+	keys, notFound, refused, err := a.checkAll(ctx, keys, concurrency)
+	if err != nil {
+		return nil, err
+	}
+	if len(refused) > 0 && !params.DryRun {
+		return nil, refusedError(refused)
+	}
+
+	var (
+		mu  sync.Mutex
+		res = &Result{NotFound: notFound, Refused: refused}
+	)
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+	for _, key := range keys {
+		g.Go(func() error {
+			found, err := a.delete(gctx, key, params.DryRun)
+			if err != nil {
+				return fmt.Errorf("bucketdelete: delete key: %s: %w", key, err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if !found {
+				res.NotFound++
+				return nil
+			}
+			if !params.DryRun {
+				res.Deleted++
+			}
+			res.Keys = append(res.Keys, key)
+
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	slices.Sort(res.Keys)
+
+	return res, nil
+}
+
+// checkAll checks the objects at keys concurrently against the safeguards,
+// returning the keys of the objects that can be deleted, the number of objects
+// found missing and the sorted refused keys with the reasons.
+func (a *Activity) checkAll(ctx context.Context, keys []string, concurrency int) ([]string, int, []string, error) {
+	var (
+		mu       sync.Mutex
+		allowed  []string
+		reasons  []string
+		notFound int
+	)
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+	for _, key := range keys {
+		g.Go(func() error {
+			found, reason, err := a.check(gctx, key)
+			if err != nil {
+				return fmt.Errorf("bucketdelete: check key: %s: %w", key, err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case !found:
+				notFound++
+			case reason != "":
+				reasons = append(reasons, fmt.Sprintf("%s: %s", key, reason))
+			default:
+				allowed = append(allowed, key)
+			}
+
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, 0, nil, err
+	}
+	slices.Sort(reasons)
+
+	return allowed, notFound, reasons, nil
+}
+
+// keys returns the unique keys of the objects to delete: Key, Keys and the
+// keys of the objects under Prefix.
+func (a *Activity) keys(ctx context.Context, params *Params) ([]string, error) {
+	var keys []string
+	if params.Key != "" {
+		keys = append(keys, params.Key)
+	}
+	keys = append(keys, params.Keys...)
+
+	if params.Prefix != "" {
+		iter := a.bucket.List(&blob.ListOptions{Prefix: dirPrefix(params.Prefix)})
+		for {
+			obj, err := iter.Next(ctx)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !obj.IsDir {
+				keys = append(keys, obj.Key)
+			}
+		}
+	}
+
+	slices.Sort(keys)
+
+	return slices.Compact(keys), nil
+}
+
+// dirPrefix returns prefix ending with a slash, so it only matches the keys
+// under it in the key hierarchy (e.g. "pkg" doesn't match "pkg2/file.txt").
+func dirPrefix(prefix string) string {
+	if strings.HasSuffix(prefix, "/") {
+		return prefix
+	}
+
+	return prefix + "/"
+}
+
+// delete deletes the object at key, or only checks that it exists if dryRun
+// is true. It returns false if the object doesn't exist.
+func (a *Activity) delete(ctx context.Context, key string, dryRun bool) (bool, error) {
+	if dryRun {
+		return a.bucket.Exists(ctx, key)
+	}
+
+	err := a.bucket.Delete(ctx, key)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
 	}
 
-	return &Result{}, nil
+	return true, nil
 }
@@ -2,9 +2,13 @@ package bucketdelete_test
 
 import (
 	"context"
+	"errors"
+	"io"
 	"testing"
+	"time"
 
 	temporalsdk_activity "go.temporal.io/sdk/activity"
+	temporalsdk_temporal "go.temporal.io/sdk/temporal"
 	temporalsdk_testsuite "go.temporal.io/sdk/testsuite"
 	"gocloud.dev/blob"
 	"gocloud.dev/blob/memblob"
@@ -61,7 +65,7 @@ func TestActivity(t *testing.T) {
 			ts := &temporalsdk_testsuite.WorkflowTestSuite{}
 			env := ts.NewTestActivityEnvironment()
 			env.RegisterActivityWithOptions(
-				bucketdelete.New(tt.bucket).Execute,
+				bucketdelete.New(tt.bucket, bucketdelete.Config{}).Execute,
 				temporalsdk_activity.RegisterOptions{Name: bucketdelete.Name},
 			)
 
@@ -84,3 +88,198 @@ func TestActivity(t *testing.T) {
 		})
 	}
 }
+
+func TestActivityBatch(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		name     string
+		params   bucketdelete.Params
+		wantRes  bucketdelete.Result
+		wantKeys []string
+	}
+	for _, tt := range []test{
+		{
+			name:   "Deletes a list of keys",
+			params: bucketdelete.Params{Key: "a.txt", Keys: []string{"pkg/b.txt", "missing.txt", "a.txt"}},
+			wantRes: bucketdelete.Result{
+				Deleted:  2,
+				NotFound: 1,
+				Keys:     []string{"a.txt", "pkg/b.txt"},
+			},
+			wantKeys: []string{"pkg/sub/c.txt", "pkg2/d.txt"},
+		},
+		{
+			name:   "Deletes a prefix",
+			params: bucketdelete.Params{Prefix: "pkg/", Concurrency: 1},
+			wantRes: bucketdelete.Result{
+				Deleted: 2,
+				Keys:    []string{"pkg/b.txt", "pkg/sub/c.txt"},
+			},
+			wantKeys: []string{"a.txt", "pkg2/d.txt"},
+		},
+		{
+			name:   "Deletes a prefix without a trailing slash",
+			params: bucketdelete.Params{Prefix: "pkg"},
+			wantRes: bucketdelete.Result{
+				Deleted: 2,
+				Keys:    []string{"pkg/b.txt", "pkg/sub/c.txt"},
+			},
+			wantKeys: []string{"a.txt", "pkg2/d.txt"},
+		},
+		{
+			name:   "Lists the keys that would be deleted",
+			params: bucketdelete.Params{Keys: []string{"a.txt", "missing.txt"}, Prefix: "pkg/", DryRun: true},
+			wantRes: bucketdelete.Result{
+				NotFound: 1,
+				Keys:     []string{"a.txt", "pkg/b.txt", "pkg/sub/c.txt"},
+			},
+			wantKeys: []string{"a.txt", "pkg/b.txt", "pkg/sub/c.txt", "pkg2/d.txt"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			b := bucket(t, "a.txt", "content")
+			for _, key := range []string{"pkg/b.txt", "pkg/sub/c.txt", "pkg2/d.txt"} {
+				assert.NilError(t, b.WriteAll(context.Background(), key, []byte("content"), nil))
+			}
+
+			ts := &temporalsdk_testsuite.WorkflowTestSuite{}
+			env := ts.NewTestActivityEnvironment()
+			env.RegisterActivityWithOptions(
+				bucketdelete.New(b, bucketdelete.Config{}).Execute,
+				temporalsdk_activity.RegisterOptions{Name: bucketdelete.Name},
+			)
+
+			enc, err := env.ExecuteActivity(bucketdelete.Name, tt.params)
+			assert.NilError(t, err)
+
+			var result bucketdelete.Result
+			_ = enc.Get(&result)
+			assert.DeepEqual(t, result, tt.wantRes)
+
+			assert.DeepEqual(t, keys(t, b), tt.wantKeys)
+		})
+	}
+}
+
+func TestActivitySafeguards(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		name     string
+		cfg      bucketdelete.Config
+		params   bucketdelete.Params
+		wantRes  bucketdelete.Result
+		wantKeys []string
+		wantErr  string
+	}
+	for _, tt := range []test{
+		{
+			name:   "Deletes the objects allowed by the safeguards",
+			cfg:    bucketdelete.Config{ProtectedKeys: []string{"*.xml"}, MinAge: time.Nanosecond},
+			params: bucketdelete.Params{Keys: []string{"a.txt", "expired.txt", "missing.txt"}},
+			wantRes: bucketdelete.Result{
+				Deleted:  2,
+				NotFound: 1,
+				Keys:     []string{"a.txt", "expired.txt"},
+			},
+			wantKeys: []string{"bad.txt", "held.txt", "pkg/METS.xml"},
+		},
+		{
+			name:    "Refuses to delete a protected key",
+			cfg:     bucketdelete.Config{ProtectedKeys: []string{"other/*", "*.xml"}},
+			params:  bucketdelete.Params{Key: "a.txt", Prefix: "pkg/"},
+			wantErr: `bucketdelete: deletion refused: pkg/METS.xml: protected key (matches "*.xml")`,
+		},
+		{
+			name:    "Refuses to delete a recent object",
+			cfg:     bucketdelete.Config{MinAge: time.Hour},
+			params:  bucketdelete.Params{Key: "a.txt"},
+			wantErr: "bucketdelete: deletion refused: a.txt: modified less than 1h0m0s ago",
+		},
+		{
+			name:   "Refuses to delete objects under retention",
+			cfg:    bucketdelete.Config{RetentionMetadataKey: "retention-until"},
+			params: bucketdelete.Params{Keys: []string{"a.txt", "bad.txt", "expired.txt", "held.txt"}},
+			wantErr: `bucketdelete: deletion refused: bad.txt: invalid retention-until metadata "soon"; ` +
+				"held.txt: retention-until 2999-01-01",
+		},
+		{
+			name:   "Reports the refusals of a dry run",
+			cfg:    bucketdelete.Config{ProtectedKeys: []string{"pkg/*"}},
+			params: bucketdelete.Params{Key: "a.txt", Prefix: "pkg/", DryRun: true},
+			wantRes: bucketdelete.Result{
+				Keys:    []string{"a.txt"},
+				Refused: []string{`pkg/METS.xml: protected key (matches "pkg/*")`},
+			},
+			wantKeys: []string{"a.txt", "bad.txt", "expired.txt", "held.txt", "pkg/METS.xml"},
+		},
+		{
+			name:    "Refuses to delete with an invalid protected key pattern",
+			cfg:     bucketdelete.Config{ProtectedKeys: []string{"["}},
+			params:  bucketdelete.Params{Key: "a.txt"},
+			wantErr: `bucketdelete: deletion refused: a.txt: invalid protected key pattern "["`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			b := bucket(t, "a.txt", "content")
+			for key, metadata := range map[string]map[string]string{
+				"pkg/METS.xml": nil,
+				"held.txt":     {"Retention-Until": "2999-01-01"},
+				"expired.txt":  {"retention-until": "2000-01-01T00:00:00Z"},
+				"bad.txt":      {"retention-until": "soon"},
+			} {
+				err := b.WriteAll(context.Background(), key, []byte("content"), &blob.WriterOptions{Metadata: metadata})
+				assert.NilError(t, err)
+			}
+
+			ts := &temporalsdk_testsuite.WorkflowTestSuite{}
+			env := ts.NewTestActivityEnvironment()
+			env.RegisterActivityWithOptions(
+				bucketdelete.New(b, tt.cfg).Execute,
+				temporalsdk_activity.RegisterOptions{Name: bucketdelete.Name},
+			)
+
+			enc, err := env.ExecuteActivity(bucketdelete.Name, tt.params)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+
+				var appErr *temporalsdk_temporal.ApplicationError
+				assert.Assert(t, errors.As(err, &appErr))
+				assert.Equal(t, appErr.Type(), "DeletionRefused")
+				assert.Assert(t, appErr.NonRetryable())
+
+				// Nothing is deleted.
+				assert.Equal(t, len(keys(t, b)), 5)
+				return
+			}
+			assert.NilError(t, err)
+
+			var result bucketdelete.Result
+			_ = enc.Get(&result)
+			assert.DeepEqual(t, result, tt.wantRes)
+			assert.DeepEqual(t, keys(t, b), tt.wantKeys)
+		})
+	}
+}
+
+func keys(t *testing.T, b *blob.Bucket) []string {
+	t.Helper()
+
+	var keys []string
+	iter := b.List(nil)
+	for {
+		obj, err := iter.Next(context.Background())
+		if err == io.EOF {
+			break
+		}
+		assert.NilError(t, err)
+		keys = append(keys, obj.Key)
+	}
+
+	return keys
+}
@@ -0,0 +1,44 @@
+package bucketdelete
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"time"
+)
+
+// Config configures the deletion safeguards, the zero value deletes any
+// object.
+type Config struct {
+	// ProtectedKeys protects the objects with keys matching any of the
+	// path.Match patterns from deletion. Patterns with a slash are matched
+	// against the key, and patterns without one against the key base name.
+	ProtectedKeys []string
+
+	// MinAge protects the objects modified less than MinAge ago from
+	// deletion.
+	MinAge time.Duration
+
+	// RetentionMetadataKey protects the objects with a date in the future in
+	// this metadata key (e.g. "retention-until") from deletion. The date must
+	// use the RFC 3339 or the "2006-01-02" format, and objects with an invalid
+	// date are protected too. The metadata key is case insensitive.
+	RetentionMetadataKey string
+}
+
+// Validate checks for invalid configuration.
+//
+// Call Validate before registering the activity if you want configuration
+// errors to fail fast instead of waiting for activity execution failures.
+func (c Config) Validate() error {
+	for _, p := range c.ProtectedKeys {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("ProtectedKeys: invalid pattern %q", p)
+		}
+	}
+	if c.MinAge < 0 {
+		return errors.New("MinAge: must not be negative")
+	}
+
+	return nil
+}
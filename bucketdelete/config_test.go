@@ -0,0 +1,55 @@
+package bucketdelete_test
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/artefactual-sdps/temporal-activities/bucketdelete"
+)
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		name    string
+		cfg     bucketdelete.Config
+		wantErr string
+	}
+
+	for _, tt := range []test{
+		{
+			name: "No errors with all the safeguards",
+			cfg: bucketdelete.Config{
+				ProtectedKeys:        []string{"aips/*", "*.xml"},
+				MinAge:               time.Hour,
+				RetentionMetadataKey: "retention-until",
+			},
+		},
+		{
+			name: "No errors with no safeguards",
+		},
+		{
+			name:    "Errors with an invalid protected key pattern",
+			cfg:     bucketdelete.Config{ProtectedKeys: []string{"*.xml", "["}},
+			wantErr: `ProtectedKeys: invalid pattern "["`,
+		},
+		{
+			name:    "Errors with a negative minimum age",
+			cfg:     bucketdelete.Config{MinAge: -time.Hour},
+			wantErr: "MinAge: must not be negative",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tt.cfg.Validate()
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
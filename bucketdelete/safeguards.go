@@ -0,0 +1,88 @@
+package bucketdelete
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	temporalsdk_temporal "go.temporal.io/sdk/temporal"
+	"gocloud.dev/gcerrors"
+)
+
+// ErrRefused is returned when the safeguards refuse to delete an object.
+var ErrRefused = errors.New("deletion refused")
+
+// check returns the reason why the safeguards refuse to delete the object at
+// key, or an empty reason if it can be deleted. It returns false if the
+// object doesn't exist, which is only checked if the object attributes are
+// needed.
+func (a *Activity) check(ctx context.Context, key string) (bool, string, error) {
+	for _, p := range a.cfg.ProtectedKeys {
+		name := key
+		if !strings.Contains(p, "/") {
+			name = path.Base(key)
+		}
+		ok, err := path.Match(p, name)
+		if err != nil {
+			// Protect the objects if Config.Validate wasn't called.
+			return true, fmt.Sprintf("invalid protected key pattern %q", p), nil
+		}
+		if ok {
+			return true, fmt.Sprintf("protected key (matches %q)", p), nil
+		}
+	}
+
+	if a.cfg.MinAge <= 0 && a.cfg.RetentionMetadataKey == "" {
+		return true, "", nil
+	}
+
+	attrs, err := a.bucket.Attributes(ctx, key)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+
+	now := time.Now()
+	if a.cfg.MinAge > 0 && now.Sub(attrs.ModTime) < a.cfg.MinAge {
+		return true, fmt.Sprintf("modified less than %s ago", a.cfg.MinAge), nil
+	}
+
+	if a.cfg.RetentionMetadataKey != "" {
+		for k, v := range attrs.Metadata {
+			if !strings.EqualFold(k, a.cfg.RetentionMetadataKey) {
+				continue
+			}
+
+			until, err := parseDate(v)
+			if err != nil {
+				return true, fmt.Sprintf("invalid %s metadata %q", a.cfg.RetentionMetadataKey, v), nil
+			}
+			if until.After(now) {
+				return true, fmt.Sprintf("%s %s", a.cfg.RetentionMetadataKey, v), nil
+			}
+		}
+	}
+
+	return true, "", nil
+}
+
+// parseDate parses an RFC 3339 date and time or a date.
+func parseDate(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.DateOnly, v)
+}
+
+// refusedError returns a non-retryable error listing the refused deletions.
+func refusedError(reasons []string) error {
+	err := fmt.Errorf("bucketdelete: %w: %s", ErrRefused, strings.Join(reasons, "; "))
+
+	return temporalsdk_temporal.NewNonRetryableApplicationError(err.Error(), "DeletionRefused", err)
+}
@@ -6,9 +6,13 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"go.artefactual.dev/tools/temporal"
 	"gocloud.dev/blob"
+
+	"github.com/artefactual-sdps/temporal-activities/internal/heartbeat"
 )
 
 const Name = "bucket-download"
@@ -30,9 +34,58 @@ type (
 
 		// Key from the object storage.
 		Key string
+
+		// DigestAlgorithm is the algorithm of the digest returned in Result,
+		// valid values are "sha256" and "sha512", default: "sha256".
+		DigestAlgorithm string
+
+		// ExpectedDigest is the hex encoded digest of the object, generated
+		// with DigestAlgorithm. If set, the download fails if the downloaded
+		// content doesn't match it.
+		ExpectedDigest string
+
+		// Prefix enables the prefix mode, downloading all the objects under
+		// Prefix in the key hierarchy to DirPath, keeping the key hierarchy
+		// relative to Prefix as subdirectories. A slash is added to Prefix if
+		// it doesn't end with one. Key, FileName and ExpectedDigest are
+		// ignored in prefix mode.
+		Prefix string
+
+		// Include and Exclude filter the objects downloaded in prefix mode
+		// with path.Match patterns. Patterns with a slash are matched against
+		// the key relative to Prefix, and patterns without one against the
+		// key base name. If Include is set, only the objects matching any of
+		// its patterns are downloaded, and the objects matching any of the
+		// Exclude patterns are never downloaded.
+		Include []string
+		Exclude []string
+
+		// Concurrency is the maximum number of objects downloaded at once in
+		// prefix mode, default: 4.
+		Concurrency int
 	}
 	Result struct {
+		// FilePath is the full path to the downloaded file, or to the target
+		// directory in prefix mode.
 		FilePath string
+
+		// Size is the size in bytes of the downloaded file, or the total
+		// size of the downloaded files in prefix mode.
+		Size int64
+
+		// MD5 is the hex encoded MD5 checksum of the downloaded file. It's
+		// empty in prefix mode.
+		MD5 string
+
+		// Digest is the hex encoded digest of the downloaded file, generated
+		// with DigestAlgorithm. It's empty in prefix mode.
+		Digest string
+
+		// DigestAlgorithm is the algorithm used to generate Digest.
+		DigestAlgorithm string
+
+		// Files lists the downloaded files, sorted by key, in prefix mode.
+		Files []File
 	}
 	Activity struct {
 		bucket *blob.Bucket
@@ -44,16 +97,32 @@ func New(bucket *blob.Bucket) *Activity {
 }
 
 func (a *Activity) Execute(ctx context.Context, params *Params) (*Result, error) {
-	h := temporal.StartAutoHeartbeat(ctx)
-	defer h.Stop()
+	alg := params.DigestAlgorithm
+	if alg == "" {
+		alg = "sha256"
+	}
+	if !slices.Contains(digestAlgorithms, alg) {
+		return nil, fmt.Errorf(
+			"bucketdownload: DigestAlgorithm: invalid value %q, must be one of (%s)",
+			alg,
+			strings.Join(digestAlgorithms, ", "),
+		)
+	}
+
+	if err := validatePatterns(params.Include); err != nil {
+		return nil, fmt.Errorf("bucketdownload: Include: %v", err)
+	}
+	if err := validatePatterns(params.Exclude); err != nil {
+		return nil, fmt.Errorf("bucketdownload: Exclude: %v", err)
+	}
 
 	var err error
+	dirPerm := fs.FileMode(0o700)
+	if params.DirPerm != 0 {
+		dirPerm = params.DirPerm
+	}
 	dirPath := params.DirPath
 	if dirPath != "" {
-		dirPerm := fs.FileMode(0o700)
-		if params.DirPerm != 0 {
-			dirPerm = params.DirPerm
-		}
 		err = os.MkdirAll(dirPath, dirPerm)
 	} else {
 		dirPath, err = os.MkdirTemp("", "bucketdownload")
@@ -62,25 +131,72 @@ func (a *Activity) Execute(ctx context.Context, params *Params) (*Result, error)
 		return nil, fmt.Errorf("bucketdownload: create directory: %w", err)
 	}
 
-	fileName := params.Key
-	if params.FileName != "" {
-		fileName = params.FileName
-	}
 	filePerm := fs.FileMode(0o600)
 	if params.FilePerm != 0 {
 		filePerm = params.FilePerm
 	}
 
-	filePath := filepath.Join(dirPath, fileName)
-	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, filePerm) // #nosec G304
+	// Files are only created through root, so object keys can't write files
+	// outside of dirPath.
+	root, err := os.OpenRoot(dirPath)
 	if err != nil {
 		return nil, fmt.Errorf("bucketdownload: create file: %w", err)
 	}
-	defer file.Close()
+	defer root.Close()
 
-	if err := a.bucket.Download(ctx, params.Key, file, &blob.ReaderOptions{}); err != nil {
-		return nil, fmt.Errorf("bucketdownload: download file: %w", err)
+	if params.Prefix != "" {
+		h := temporal.StartAutoHeartbeat(ctx)
+		defer h.Stop()
+
+		files, err := a.downloadPrefix(ctx, root, dirPerm, filePerm, alg, params)
+		if err != nil {
+			return nil, downloadError(err)
+		}
+
+		res := &Result{FilePath: dirPath, DigestAlgorithm: alg, Files: files}
+		for _, f := range files {
+			res.Size += f.Size
+		}
+
+		return res, nil
+	}
+
+	fileName := params.Key
+	if params.FileName != "" {
+		fileName = params.FileName
+	}
+	name, err := localName(fileName)
+	if err != nil {
+		return nil, downloadError(err)
+	}
+	if err := mkdirParents(root, name, dirPerm); err != nil {
+		return nil, fmt.Errorf("bucketdownload: create directory: %w", err)
+	}
+
+	// Keep the permissions of an existing file if they are not set.
+	if fi, err := root.Stat(name); err == nil && params.FilePerm == 0 {
+		filePerm = fi.Mode().Perm()
+	}
+
+	h := heartbeat.Start[Progress](ctx)
+	defer h.Stop()
+
+	// Keep recording the previous progress until the download is resumed.
+	if prev, ok := previousProgress(ctx); ok {
+		h.Set(prev)
+	}
+
+	sums, err := a.downloadFile(ctx, root, params.Key, name, filePerm, alg, params.ExpectedDigest, h)
+	if err != nil {
+		return nil, downloadError(err)
 	}
 
-	return &Result{FilePath: filePath}, nil
+	filePath := filepath.Join(dirPath, name)
+	return &Result{
+		FilePath:        filePath,
+		Size:            sums.size,
+		MD5:             sums.hexMD5(),
+		Digest:          sums.hexDigest(),
+		DigestAlgorithm: alg,
+	}, nil
 }
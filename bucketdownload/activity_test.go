@@ -2,10 +2,15 @@ package bucketdownload_test
 
 import (
 	"context"
+	"errors"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	temporalsdk_activity "go.temporal.io/sdk/activity"
+	temporalsdk_converter "go.temporal.io/sdk/converter"
+	temporalsdk_temporal "go.temporal.io/sdk/temporal"
 	temporalsdk_testsuite "go.temporal.io/sdk/testsuite"
 	"gocloud.dev/blob"
 	"gocloud.dev/blob/memblob"
@@ -15,6 +20,12 @@ import (
 	"github.com/artefactual-sdps/temporal-activities/bucketdownload"
 )
 
+const (
+	contentMD5    = "9a0364b9e99bb480dd25e1f0284c8555"
+	contentSHA256 = "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73"
+	contentSHA512 = "b2d1d285b5199c85f988d03649c37e44fd3dde01e5d69c50fef90651962f48110e9340b60d49a479c4c0b53f5f07d690686dd87d2481937a512e8b85ee7c617f"
+)
+
 func bucket(t *testing.T, key, contents string) *blob.Bucket {
 	t.Helper()
 
@@ -54,7 +65,11 @@ func TestActivity(t *testing.T) {
 						Key:     "file.txt",
 					},
 					wantRes: bucketdownload.Result{
-						FilePath: filepath.Join(dir, "new", "file.txt"),
+						FilePath:        filepath.Join(dir, "new", "file.txt"),
+						Size:            7,
+						MD5:             contentMD5,
+						Digest:          contentSHA256,
+						DigestAlgorithm: "sha256",
 					},
 				}
 			},
@@ -78,7 +93,11 @@ func TestActivity(t *testing.T) {
 						FilePerm: 0o644,
 					},
 					wantRes: bucketdownload.Result{
-						FilePath: filepath.Join(dir, "new", "changed.txt"),
+						FilePath:        filepath.Join(dir, "new", "changed.txt"),
+						Size:            7,
+						MD5:             contentMD5,
+						Digest:          contentSHA256,
+						DigestAlgorithm: "sha256",
 					},
 				}
 			},
@@ -112,7 +131,11 @@ func TestActivity(t *testing.T) {
 						Key:     "file.txt",
 					},
 					wantRes: bucketdownload.Result{
-						FilePath: filepath.Join(dir, "empty", "file.txt"),
+						FilePath:        filepath.Join(dir, "empty", "file.txt"),
+						Size:            7,
+						MD5:             contentMD5,
+						Digest:          contentSHA256,
+						DigestAlgorithm: "sha256",
 					},
 				}
 			},
@@ -141,7 +164,11 @@ func TestActivity(t *testing.T) {
 						Key:     "file.txt",
 					},
 					wantRes: bucketdownload.Result{
-						FilePath: filepath.Join(dir, "withfile", "file.txt"),
+						FilePath:        filepath.Join(dir, "withfile", "file.txt"),
+						Size:            7,
+						MD5:             contentMD5,
+						Digest:          contentSHA256,
+						DigestAlgorithm: "sha256",
 					},
 				}
 			},
@@ -231,3 +258,426 @@ func TestActivity(t *testing.T) {
 		})
 	}
 }
+
+func TestActivityVerification(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		name    string
+		params  bucketdownload.Params
+		wantRes bucketdownload.Result
+		wantErr string
+	}
+	for _, tt := range []test{
+		{
+			name: "Downloads a file matching the expected digest",
+			params: bucketdownload.Params{
+				DigestAlgorithm: "sha512",
+				ExpectedDigest:  strings.ToUpper(contentSHA512),
+			},
+			wantRes: bucketdownload.Result{
+				Size:            7,
+				MD5:             contentMD5,
+				Digest:          contentSHA512,
+				DigestAlgorithm: "sha512",
+			},
+		},
+		{
+			name:    "Fails if the digest doesn't match",
+			params:  bucketdownload.Params{ExpectedDigest: contentMD5},
+			wantErr: "bucketdownload: verify download: digest mismatch: expected " + contentMD5 + ", got " + contentSHA256,
+		},
+		{
+			name:    "Fails with an invalid digest algorithm",
+			params:  bucketdownload.Params{DigestAlgorithm: "md5"},
+			wantErr: `bucketdownload: DigestAlgorithm: invalid value "md5", must be one of (sha256, sha512)`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := &temporalsdk_testsuite.WorkflowTestSuite{}
+			env := ts.NewTestActivityEnvironment()
+			env.RegisterActivityWithOptions(
+				bucketdownload.New(bucket(t, "file.txt", "content")).Execute,
+				temporalsdk_activity.RegisterOptions{Name: bucketdownload.Name},
+			)
+
+			dir := fs.NewDir(t, "bucketdownload_test", fs.WithFile("file.txt", "old content"))
+			params := tt.params
+			params.DirPath = dir.Path()
+			params.Key = "file.txt"
+
+			enc, err := env.ExecuteActivity(bucketdownload.Name, params)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+
+				// The existing file is kept and no partial file is left.
+				assert.Assert(t, fs.Equal(dir.Path(), fs.Expected(
+					t,
+					fs.WithMode(0o700),
+					fs.WithFile("file.txt", "old content", fs.WithMode(0o644)),
+				)))
+				return
+			}
+			assert.NilError(t, err)
+
+			var result bucketdownload.Result
+			err = enc.Get(&result)
+			assert.NilError(t, err)
+
+			tt.wantRes.FilePath = filepath.Join(dir.Path(), "file.txt")
+			assert.DeepEqual(t, result, tt.wantRes)
+			assert.Assert(t, fs.Equal(dir.Path(), fs.Expected(
+				t,
+				fs.WithMode(0o700),
+				fs.WithFile("file.txt", "content", fs.WithMode(0o644)),
+			)))
+		})
+	}
+}
+
+func TestActivityResume(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		name       string
+		partial    string
+		details    func(attrs *blob.Attributes) *bucketdownload.Progress
+		wantOffset int64
+	}
+	for _, tt := range []test{
+		{
+			name:       "Downloads a file from the start",
+			wantOffset: 0,
+		},
+		{
+			name:    "Resumes a download from the recorded offset",
+			partial: "contXX",
+			details: func(attrs *blob.Attributes) *bucketdownload.Progress {
+				return &bucketdownload.Progress{
+					Key:     "file.txt",
+					ETag:    attrs.ETag,
+					ModTime: attrs.ModTime,
+					Size:    attrs.Size,
+					Offset:  4,
+				}
+			},
+			wantOffset: 4,
+		},
+		{
+			name:    "Starts over if the object changed",
+			partial: "CONT",
+			details: func(attrs *blob.Attributes) *bucketdownload.Progress {
+				return &bucketdownload.Progress{
+					Key:     "file.txt",
+					ETag:    `"changed"`,
+					ModTime: attrs.ModTime,
+					Size:    attrs.Size,
+					Offset:  4,
+				}
+			},
+			wantOffset: 0,
+		},
+		{
+			name:    "Starts over if the partial file is shorter than the offset",
+			partial: "co",
+			details: func(attrs *blob.Attributes) *bucketdownload.Progress {
+				return &bucketdownload.Progress{
+					Key:     "file.txt",
+					ETag:    attrs.ETag,
+					ModTime: attrs.ModTime,
+					Size:    attrs.Size,
+					Offset:  4,
+				}
+			},
+			wantOffset: 0,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			b := bucket(t, "file.txt", "content")
+			attrs, err := b.Attributes(context.Background(), "file.txt")
+			assert.NilError(t, err)
+
+			dir := fs.NewDir(t, "bucketdownload_test")
+			if tt.partial != "" {
+				fs.Apply(t, dir, fs.WithFile(".file.txt.part", tt.partial))
+			}
+
+			var heartbeats []bucketdownload.Progress
+			ts := &temporalsdk_testsuite.WorkflowTestSuite{}
+			env := ts.NewTestActivityEnvironment()
+			if tt.details != nil {
+				env.SetHeartbeatDetails(tt.details(attrs))
+			}
+			env.SetOnActivityHeartbeatListener(
+				func(_ *temporalsdk_activity.Info, details temporalsdk_converter.EncodedValues) {
+					var p bucketdownload.Progress
+					_ = details.Get(&p)
+					heartbeats = append(heartbeats, p)
+				},
+			)
+			env.RegisterActivityWithOptions(
+				bucketdownload.New(b).Execute,
+				temporalsdk_activity.RegisterOptions{Name: bucketdownload.Name},
+			)
+
+			enc, err := env.ExecuteActivity(bucketdownload.Name, bucketdownload.Params{
+				DirPath: dir.Path(),
+				Key:     "file.txt",
+			})
+			assert.NilError(t, err)
+
+			var result bucketdownload.Result
+			err = enc.Get(&result)
+			assert.NilError(t, err)
+			assert.Equal(t, result.MD5, contentMD5)
+
+			// The first heartbeat shows where the download was resumed.
+			assert.Assert(t, len(heartbeats) > 0)
+			assert.Equal(t, heartbeats[0].Offset, tt.wantOffset)
+			assert.Assert(t, fs.Equal(dir.Path(), fs.Expected(
+				t,
+				fs.WithMode(0o700),
+				fs.WithFile("file.txt", "content", fs.WithMode(0o600)),
+			)))
+		})
+	}
+}
+
+func TestActivityResumeKeepsProgress(t *testing.T) {
+	t.Parallel()
+
+	b := bucket(t, "file.txt", "content")
+	details := bucketdownload.Progress{Key: "missing.txt", Size: 7, Offset: 4}
+
+	var heartbeats []bucketdownload.Progress
+	ts := &temporalsdk_testsuite.WorkflowTestSuite{}
+	env := ts.NewTestActivityEnvironment()
+	env.SetHeartbeatDetails(details)
+	env.SetOnActivityHeartbeatListener(
+		func(_ *temporalsdk_activity.Info, details temporalsdk_converter.EncodedValues) {
+			var p bucketdownload.Progress
+			_ = details.Get(&p)
+			heartbeats = append(heartbeats, p)
+		},
+	)
+	env.RegisterActivityWithOptions(
+		bucketdownload.New(b).Execute,
+		temporalsdk_activity.RegisterOptions{Name: bucketdownload.Name},
+	)
+
+	_, err := env.ExecuteActivity(bucketdownload.Name, bucketdownload.Params{
+		DirPath: fs.NewDir(t, "bucketdownload_test").Path(),
+		Key:     "missing.txt",
+	})
+	assert.ErrorContains(t, err, "bucketdownload:")
+
+	// The download failed before it was resumed, the recorded progress is
+	// kept for the next attempt.
+	assert.DeepEqual(t, heartbeats, []bucketdownload.Progress{details})
+}
+
+func TestActivityPrefix(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		name      string
+		params    bucketdownload.Params
+		wantFiles []string
+		wantFs    fs.Manifest
+		wantErr   string
+	}
+	for _, tt := range []test{
+		{
+			name:      "Downloads all the objects under a prefix",
+			params:    bucketdownload.Params{Prefix: "pkg/"},
+			wantFiles: []string{"pkg/file.txt", "pkg/sub/file.xml"},
+			wantFs: fs.Expected(
+				t,
+				fs.WithMode(0o700),
+				fs.WithFile("file.txt", "content", fs.WithMode(0o600)),
+				fs.WithDir("sub", fs.WithMode(0o700), fs.WithFile("file.xml", "content", fs.WithMode(0o600))),
+			),
+		},
+		{
+			name:      "Downloads the objects under a prefix without a trailing slash",
+			params:    bucketdownload.Params{Prefix: "pkg"},
+			wantFiles: []string{"pkg/file.txt", "pkg/sub/file.xml"},
+			wantFs: fs.Expected(
+				t,
+				fs.WithMode(0o700),
+				fs.WithFile("file.txt", "content", fs.WithMode(0o600)),
+				fs.WithDir("sub", fs.WithMode(0o700), fs.WithFile("file.xml", "content", fs.WithMode(0o600))),
+			),
+		},
+		{
+			name:      "Downloads the included objects",
+			params:    bucketdownload.Params{Prefix: "pkg/", Include: []string{"*.xml"}},
+			wantFiles: []string{"pkg/sub/file.xml"},
+			wantFs: fs.Expected(
+				t,
+				fs.WithMode(0o700),
+				fs.WithDir("sub", fs.WithMode(0o700), fs.WithFile("file.xml", "content", fs.WithMode(0o600))),
+			),
+		},
+		{
+			name:      "Skips the excluded objects",
+			params:    bucketdownload.Params{Prefix: "pkg/", Exclude: []string{"sub/*"}, Concurrency: 1},
+			wantFiles: []string{"pkg/file.txt"},
+			wantFs: fs.Expected(
+				t,
+				fs.WithMode(0o700),
+				fs.WithFile("file.txt", "content", fs.WithMode(0o600)),
+			),
+		},
+		{
+			name:    "Fails with an invalid pattern",
+			params:  bucketdownload.Params{Prefix: "pkg/", Include: []string{"["}},
+			wantErr: `bucketdownload: Include: invalid pattern "["`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			b := bucket(t, "other/file.txt", "content")
+			for _, key := range []string{"pkg/file.txt", "pkg/sub/file.xml", "pkg/sub/", "pkg2/file.txt"} {
+				assert.NilError(t, b.WriteAll(context.Background(), key, []byte("content"), nil))
+			}
+
+			ts := &temporalsdk_testsuite.WorkflowTestSuite{}
+			env := ts.NewTestActivityEnvironment()
+			env.RegisterActivityWithOptions(
+				bucketdownload.New(b).Execute,
+				temporalsdk_activity.RegisterOptions{Name: bucketdownload.Name},
+			)
+
+			dir := fs.NewDir(t, "bucketdownload_test")
+			params := tt.params
+			params.DirPath = dir.Path()
+
+			enc, err := env.ExecuteActivity(bucketdownload.Name, params)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+
+			var result bucketdownload.Result
+			err = enc.Get(&result)
+			assert.NilError(t, err)
+			assert.Equal(t, result.FilePath, dir.Path())
+			assert.Equal(t, result.Size, int64(7*len(tt.wantFiles)))
+
+			var files []string
+			for _, f := range result.Files {
+				assert.Equal(t, f.FilePath, filepath.Join(dir.Path(), strings.TrimPrefix(f.Key, "pkg/")))
+				assert.Equal(t, f.MD5, contentMD5)
+				assert.Equal(t, f.Digest, contentSHA256)
+				files = append(files, f.Key)
+			}
+			assert.DeepEqual(t, files, tt.wantFiles)
+			assert.Assert(t, fs.Equal(dir.Path(), tt.wantFs))
+		})
+	}
+}
+
+func TestActivityPaths(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		name         string
+		key          string
+		symlink      bool
+		params       bucketdownload.Params
+		wantFilePath string
+		wantFs       fs.Manifest
+		wantErr      string
+		nonRetryable bool
+	}
+	for _, tt := range []test{
+		{
+			name:         "Creates the key directories",
+			key:          "a/b/file.txt",
+			params:       bucketdownload.Params{Key: "a/b/file.txt"},
+			wantFilePath: filepath.Join("a", "b", "file.txt"),
+			wantFs: fs.Expected(
+				t,
+				fs.WithMode(0o755),
+				fs.WithDir("a", fs.WithMode(0o700), fs.WithDir("b", fs.WithMode(0o700),
+					fs.WithFile("file.txt", "content", fs.WithMode(0o600)),
+				)),
+			),
+		},
+		{
+			name:         "Rejects a key escaping the target directory",
+			key:          "../outside/file.txt",
+			params:       bucketdownload.Params{Key: "../outside/file.txt"},
+			wantErr:      `bucketdownload: path escapes the target directory: "../outside/file.txt"`,
+			nonRetryable: true,
+		},
+		{
+			name:         "Rejects an absolute filename",
+			key:          "file.txt",
+			params:       bucketdownload.Params{Key: "file.txt", FileName: "/outside/file.txt"},
+			wantErr:      `bucketdownload: path escapes the target directory: "/outside/file.txt"`,
+			nonRetryable: true,
+		},
+		{
+			name:         "Rejects a prefix key escaping the target directory",
+			key:          "pkg/../../outside/file.txt",
+			params:       bucketdownload.Params{Prefix: "pkg/"},
+			wantErr:      `bucketdownload: pkg/../../outside/file.txt: path escapes the target directory: "../../outside/file.txt"`,
+			nonRetryable: true,
+		},
+		{
+			name:    "Fails to follow a symlink out of the target directory",
+			key:     "link/file.txt",
+			symlink: true,
+			params:  bucketdownload.Params{Key: "link/file.txt"},
+			wantErr: "bucketdownload: create directory: mkdirat link: statat link: path escapes from parent",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := &temporalsdk_testsuite.WorkflowTestSuite{}
+			env := ts.NewTestActivityEnvironment()
+			env.RegisterActivityWithOptions(
+				bucketdownload.New(bucket(t, tt.key, "content")).Execute,
+				temporalsdk_activity.RegisterOptions{Name: bucketdownload.Name},
+			)
+
+			tmp := fs.NewDir(t, "bucketdownload_test", fs.WithDir("outside"), fs.WithDir("target"))
+			if tt.symlink {
+				assert.NilError(t, os.Symlink(tmp.Join("outside"), tmp.Join("target", "link")))
+			}
+			params := tt.params
+			params.DirPath = tmp.Join("target")
+
+			enc, err := env.ExecuteActivity(bucketdownload.Name, params)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+
+				var appErr *temporalsdk_temporal.ApplicationError
+				assert.Assert(t, errors.As(err, &appErr))
+				assert.Equal(t, appErr.NonRetryable(), tt.nonRetryable)
+
+				// Nothing is written outside of the target directory.
+				entries, err := os.ReadDir(tmp.Join("outside"))
+				assert.NilError(t, err)
+				assert.Equal(t, len(entries), 0)
+				return
+			}
+			assert.NilError(t, err)
+
+			var result bucketdownload.Result
+			err = enc.Get(&result)
+			assert.NilError(t, err)
+			assert.Equal(t, result.FilePath, filepath.Join(params.DirPath, tt.wantFilePath))
+			assert.Assert(t, fs.Equal(params.DirPath, tt.wantFs))
+		})
+	}
+}
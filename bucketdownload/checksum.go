@@ -0,0 +1,70 @@
+package bucketdownload
+
+import (
+	"bytes"
+	"crypto/md5" // #nosec G501 -- MD5 is used to check the object integrity.
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// digestAlgorithms lists the supported digest algorithms.
+var digestAlgorithms = []string{"sha256", "sha512"}
+
+// hasher is an io.Writer that generates the size, MD5 and digest of the
+// content written to it.
+type hasher struct {
+	size   int64
+	md5    hash.Hash
+	digest hash.Hash
+}
+
+// newHasher returns a hasher generating a digest with the given algorithm.
+func newHasher(alg string) *hasher {
+	h := &hasher{md5: md5.New()} // #nosec G401 -- MD5 is used to check the object integrity.
+	if alg == "sha512" {
+		h.digest = sha512.New()
+	} else {
+		h.digest = sha256.New()
+	}
+
+	return h
+}
+
+func (h *hasher) Write(p []byte) (int, error) {
+	h.md5.Write(p)
+	h.digest.Write(p)
+	h.size += int64(len(p))
+
+	return len(p), nil
+}
+
+// verify checks the written content against the size and MD5 of the object,
+// and against the expected hex encoded digest if it's not empty. The MD5 is
+// only checked if the provider returns it.
+func (h *hasher) verify(size int64, md5 []byte, digest string) error {
+	if h.size != size {
+		return fmt.Errorf("size mismatch: expected %d bytes, got %d bytes", size, h.size)
+	}
+	if got := h.md5.Sum(nil); len(md5) > 0 && !bytes.Equal(md5, got) {
+		return fmt.Errorf("MD5 mismatch: expected %s, got %s", hex.EncodeToString(md5), hex.EncodeToString(got))
+	}
+	if got := hex.EncodeToString(h.digest.Sum(nil)); digest != "" && !strings.EqualFold(digest, got) {
+		return fmt.Errorf("digest mismatch: expected %s, got %s", digest, got)
+	}
+
+	return nil
+}
+
+// hexMD5 returns the hex encoded MD5 of the written content.
+func (h *hasher) hexMD5() string {
+	return hex.EncodeToString(h.md5.Sum(nil))
+}
+
+// hexDigest returns the hex encoded digest of the written content.
+func (h *hasher) hexDigest() string {
+	return hex.EncodeToString(h.digest.Sum(nil))
+}
@@ -0,0 +1,49 @@
+package bucketdownload
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	temporalsdk_temporal "go.temporal.io/sdk/temporal"
+)
+
+// ErrUnsafePath is returned when an object key or filename would write a file
+// outside of the target directory.
+var ErrUnsafePath = errors.New("path escapes the target directory")
+
+// localName returns name, a slash separated object key or filename, as a
+// clean path relative to the target directory. It returns ErrUnsafePath if
+// name is empty, absolute or escapes the target directory.
+func localName(name string) (string, error) {
+	n := filepath.FromSlash(name)
+	if !filepath.IsLocal(n) {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+	}
+
+	return filepath.Clean(n), nil
+}
+
+// mkdirParents creates the missing parent directories of name in root with
+// dirPerm permissions.
+func mkdirParents(root *os.Root, name string, dirPerm fs.FileMode) error {
+	dir := filepath.Dir(name)
+	if dir == "." {
+		return nil
+	}
+
+	return root.MkdirAll(dir, dirPerm)
+}
+
+// downloadError wraps err, making it non-retryable if the download failed
+// because of an unsafe path.
+func downloadError(err error) error {
+	err = fmt.Errorf("bucketdownload: %w", err)
+	if errors.Is(err, ErrUnsafePath) {
+		return temporalsdk_temporal.NewNonRetryableApplicationError(err.Error(), "UnsafePath", err)
+	}
+
+	return err
+}
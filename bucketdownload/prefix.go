@@ -0,0 +1,174 @@
+package bucketdownload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gocloud.dev/blob"
+	"golang.org/x/sync/errgroup"
+)
+
+const defaultConcurrency = 4
+
+// File is a file downloaded in prefix mode.
+type File struct {
+	// Key of the downloaded object.
+	Key string
+
+	// FilePath is the full path to the downloaded file.
+	FilePath string
+
+	// Size is the size in bytes of the downloaded file.
+	Size int64
+
+	// MD5 is the hex encoded MD5 checksum of the downloaded file.
+	MD5 string
+
+	// Digest is the hex encoded digest of the downloaded file.
+	Digest string
+}
+
+// validatePatterns returns an error if any of the patterns is malformed.
+func validatePatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", p)
+		}
+	}
+
+	return nil
+}
+
+// matchAny returns true if key matches any of the patterns. Patterns with a
+// slash are matched against the key, and patterns without one against the
+// key base name.
+func matchAny(patterns []string, key string) bool {
+	for _, p := range patterns {
+		name := key
+		if !strings.Contains(p, "/") {
+			name = path.Base(key)
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// object is an object listed under a prefix.
+type object struct {
+	// key of the object and rel, the key relative to the prefix.
+	key, rel string
+}
+
+// dirPrefix returns prefix ending with a slash, so it only matches the keys
+// under it in the key hierarchy (e.g. "pkg" doesn't match "pkg2/file.txt").
+func dirPrefix(prefix string) string {
+	if strings.HasSuffix(prefix, "/") {
+		return prefix
+	}
+
+	return prefix + "/"
+}
+
+// listObjects returns the objects under prefix that match the include and
+// exclude patterns, sorted by key.
+func (a *Activity) listObjects(ctx context.Context, prefix string, include, exclude []string) ([]object, error) {
+	prefix = dirPrefix(prefix)
+
+	var objects []object
+	iter := a.bucket.List(&blob.ListOptions{Prefix: prefix})
+	for {
+		obj, err := iter.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Skip directory markers.
+		if obj.IsDir || strings.HasSuffix(obj.Key, "/") {
+			continue
+		}
+
+		rel := strings.TrimLeft(strings.TrimPrefix(obj.Key, prefix), "/")
+		if len(include) > 0 && !matchAny(include, rel) {
+			continue
+		}
+		if matchAny(exclude, rel) {
+			continue
+		}
+
+		objects = append(objects, object{key: obj.Key, rel: rel})
+	}
+
+	return objects, nil
+}
+
+// downloadPrefix downloads the objects under prefix concurrently to root,
+// keeping the key hierarchy relative to prefix as subdirectories.
+func (a *Activity) downloadPrefix(
+	ctx context.Context,
+	root *os.Root,
+	dirPerm, filePerm fs.FileMode,
+	alg string,
+	params *Params,
+) ([]File, error) {
+	objects, err := a.listObjects(ctx, params.Prefix, params.Include, params.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("list objects: %w", err)
+	}
+
+	concurrency := defaultConcurrency
+	if params.Concurrency > 0 {
+		concurrency = params.Concurrency
+	}
+
+	files := make([]File, len(objects))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+	for i, o := range objects {
+		g.Go(func() error {
+			name, err := localName(o.rel)
+			if err != nil {
+				return fmt.Errorf("%s: %w", o.key, err)
+			}
+			if err := mkdirParents(root, name, dirPerm); err != nil {
+				return fmt.Errorf("%s: create directory: %w", o.key, err)
+			}
+
+			sums, err := a.downloadFile(gctx, root, o.key, name, filePerm, alg, "", nil)
+			if err != nil {
+				return fmt.Errorf("%s: %w", o.key, err)
+			}
+			files[i] = newFile(o.key, filepath.Join(root.Name(), name), sums)
+
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// newFile returns the File downloaded from key to filePath.
+func newFile(key, filePath string, sums *hasher) File {
+	return File{
+		Key:      key,
+		FilePath: filePath,
+		Size:     sums.size,
+		MD5:      sums.hexMD5(),
+		Digest:   sums.hexDigest(),
+	}
+}
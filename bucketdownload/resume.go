@@ -0,0 +1,185 @@
+package bucketdownload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	temporalsdk_activity "go.temporal.io/sdk/activity"
+
+	"github.com/artefactual-sdps/temporal-activities/internal/heartbeat"
+)
+
+// Progress is the progress of a download, recorded in the activity heartbeat
+// details to resume the download when the activity is retried.
+type Progress struct {
+	// Key of the downloaded object.
+	Key string
+
+	// ETag, ModTime and Size of the downloaded object, used to check that the
+	// object didn't change before resuming the download.
+	ETag    string
+	ModTime time.Time
+	Size    int64
+
+	// Offset is the number of bytes written to the partial file.
+	Offset int64
+}
+
+// partName returns the name of the partial file used to download name.
+func partName(name string) string {
+	return filepath.Join(filepath.Dir(name), "."+filepath.Base(name)+".part")
+}
+
+// downloadFile downloads the object at key to a partial file in root, renamed
+// to name with filePerm permissions once the download is verified against
+// the object and the expected digest. If h is not nil, the progress is
+// recorded in the heartbeats and a previous download of the same object
+// recorded in the heartbeat details is resumed from its offset. The partial
+// file is kept if the download fails, to be resumed on retry.
+func (a *Activity) downloadFile(
+	ctx context.Context,
+	root *os.Root,
+	key, name string,
+	filePerm fs.FileMode,
+	alg, digest string,
+	h *heartbeat.Heartbeat[Progress],
+) (*hasher, error) {
+	attrs, err := a.bucket.Attributes(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("download file: %w", err)
+	}
+
+	p := Progress{Key: key, ETag: attrs.ETag, ModTime: attrs.ModTime, Size: attrs.Size}
+	if h != nil {
+		p.Offset = resumeOffset(ctx, p)
+	}
+
+	part := partName(name)
+	file, err := root.OpenFile(part, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("create file: %w", err)
+	}
+	defer file.Close()
+
+	sums := newHasher(alg)
+	if p.Offset, err = resumeFile(file, sums, p.Offset); err != nil {
+		return nil, fmt.Errorf("resume file: %w", err)
+	}
+	if h != nil {
+		h.Update(p)
+	}
+
+	if p.Offset < p.Size {
+		if err := a.downloadRange(ctx, key, io.MultiWriter(file, sums), &p, h); err != nil {
+			return nil, fmt.Errorf("download file: %w", err)
+		}
+	}
+
+	if err := sums.verify(attrs.Size, attrs.MD5, digest); err != nil {
+		_ = file.Close()
+		_ = root.Remove(part)
+		return nil, fmt.Errorf("verify download: %w", err)
+	}
+
+	if err := file.Chmod(filePerm); err != nil {
+		return nil, fmt.Errorf("write file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("write file: %w", err)
+	}
+	if err := root.Rename(part, name); err != nil {
+		return nil, fmt.Errorf("write file: %w", err)
+	}
+
+	return sums, nil
+}
+
+// previousProgress returns the progress recorded in the heartbeat details of
+// a previous attempt, if any.
+func previousProgress(ctx context.Context) (Progress, bool) {
+	if !temporalsdk_activity.HasHeartbeatDetails(ctx) {
+		return Progress{}, false
+	}
+
+	var prev Progress
+	if err := temporalsdk_activity.GetHeartbeatDetails(ctx, &prev); err != nil {
+		return Progress{}, false
+	}
+
+	return prev, true
+}
+
+// resumeOffset returns the offset of a previous download of the same object
+// recorded in the heartbeat details, or zero.
+func resumeOffset(ctx context.Context, p Progress) int64 {
+	prev, ok := previousProgress(ctx)
+	if !ok {
+		return 0
+	}
+	if prev.Key != p.Key || prev.ETag != p.ETag || !prev.ModTime.Equal(p.ModTime) || prev.Size != p.Size {
+		return 0
+	}
+
+	return prev.Offset
+}
+
+// resumeFile prepares the partial file to resume the download at offset,
+// truncating it to offset and hashing its content. It returns zero, starting
+// the download over, if the file is shorter than offset.
+func resumeFile(file *os.File, sums *hasher, offset int64) (int64, error) {
+	fi, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if fi.Size() < offset {
+		offset = 0
+	}
+
+	if err := file.Truncate(offset); err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(sums, io.NewSectionReader(file, 0, offset)); err != nil {
+		return 0, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	return offset, nil
+}
+
+// downloadRange writes the object at key to w from the progress offset,
+// updating the progress as the content is written.
+func (a *Activity) downloadRange(ctx context.Context, key string, w io.Writer, p *Progress, h *heartbeat.Heartbeat[Progress]) error {
+	r, err := a.bucket.NewRangeReader(ctx, key, p.Offset, -1, nil)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	_, err = io.Copy(&progressWriter{w: w, p: p, h: h}, r)
+
+	return err
+}
+
+// progressWriter updates the download progress with the bytes written to w.
+type progressWriter struct {
+	w io.Writer
+	p *Progress
+	h *heartbeat.Heartbeat[Progress]
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	pw.p.Offset += int64(n)
+	if pw.h != nil {
+		pw.h.Set(*pw.p)
+	}
+
+	return n, err
+}
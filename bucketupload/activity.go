@@ -1,25 +1,138 @@
 package bucketupload
 
 import (
+	"bytes"
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"go.artefactual.dev/tools/temporal"
+	temporalsdk_temporal "go.temporal.io/sdk/temporal"
 	"gocloud.dev/blob"
+	"golang.org/x/sync/errgroup"
 )
 
-const Name = "bucket-upload"
+const (
+	Name               = "bucket-upload"
+	defaultConcurrency = 4
+
+	// preHashMaxSize is the maximum size in bytes of the files hashed before
+	// uploading them, to send their MD5 checksum with the upload.
+	preHashMaxSize = 32 << 20
+)
 
 type (
 	Params struct {
-		Path       string
-		Key        string
+		// Path of the file to upload. If Path is a directory, all the files in
+		// the directory tree are uploaded.
+		Path string
+
+		// Key of the uploaded object, default: the Path base name. If Path is a
+		// directory, Key is the prefix of the object keys, which are the
+		// prefix joined with the file paths relative to Path (e.g.
+		// "prefix/dir/file.txt").
+		Key string
+
 		BufferSize int
+
+		// DigestAlgorithm is the algorithm of the digest returned in Result,
+		// valid values are "sha256" and "sha512", default: "sha256".
+		DigestAlgorithm string
+
+		// Concurrency is the maximum number of files uploaded at once when
+		// Path is a directory, default: 4.
+		Concurrency int
+
+		// PartSize enables staged uploads, uploading the file in staged parts
+		// of PartSize bytes that are composed into Key once all of them are
+		// uploaded, with the provider's native multipart upload if possible.
+		// The upload Progress is recorded in the heartbeat details and a
+		// retried activity continues staging the file from the last
+		// confirmed part, composing the object can't be resumed. It must be
+		// at least 5 MiB in S3 buckets. PartSize is ignored if Path is a
+		// directory.
+		PartSize int64
+
+		// ContentType of the uploaded objects. If empty, the content type is
+		// detected if DetectContentType is true, or set to
+		// "application/octet-stream" otherwise.
+		ContentType string
+
+		// DetectContentType detects the content type of the uploaded objects
+		// from the file extension or, if unknown, from the file content. It's
+		// ignored if ContentType is set.
+		DetectContentType bool
+
+		// ContentDisposition and CacheControl set the Content-Disposition and
+		// Cache-Control of the uploaded objects.
+		ContentDisposition string
+		CacheControl       string
+
+		// Metadata is set as the metadata of the uploaded objects. The keys
+		// may be lowercased by the bucket.
+		Metadata map[string]string
+
+		// Policy sets how existing objects are handled, default:
+		// PolicyOverwrite.
+		Policy Policy
 	}
 	Result struct {
+		// Key of the uploaded object, or the key prefix if Path is a
+		// directory.
 		Key string
+
+		// Size is the size in bytes of the uploaded object, or the total size
+		// of the uploaded objects if Path is a directory.
+		Size int64
+
+		// MD5 is the hex encoded MD5 checksum of the uploaded object. It's
+		// empty if Path is a directory.
+		MD5 string
+
+		// Digest is the hex encoded digest of the uploaded object, generated
+		// with DigestAlgorithm. It's empty if Path is a directory.
+		Digest string
+
+		// DigestAlgorithm is the algorithm used to generate Digest.
+		DigestAlgorithm string
+
+		// ContentType is the content type of the uploaded object. It's empty
+		// if Path is a directory.
+		ContentType string
+
+		// Outcome of the upload. It's empty if Path is a directory.
+		Outcome Outcome
+
+		// Objects lists the uploaded objects, sorted by key, if Path is a
+		// directory.
+		Objects []Object
+	}
+	Object struct {
+		// Key of the uploaded object.
+		Key string
+
+		// Size is the size in bytes of the uploaded object.
+		Size int64
+
+		// MD5 is the hex encoded MD5 checksum of the uploaded object.
+		MD5 string
+
+		// Digest is the hex encoded digest of the uploaded object.
+		Digest string
+
+		// ContentType is the content type of the uploaded object.
+		ContentType string
+
+		// Outcome of the upload.
+		Outcome Outcome
 	}
 	Activity struct {
 		bucket *blob.Bucket
@@ -31,27 +144,253 @@ func New(bucket *blob.Bucket) *Activity {
 }
 
 func (a *Activity) Execute(ctx context.Context, params *Params) (*Result, error) {
-	h := temporal.StartAutoHeartbeat(ctx)
-	defer h.Stop()
+	alg := params.DigestAlgorithm
+	if alg == "" {
+		alg = "sha256"
+	}
+	if !slices.Contains(digestAlgorithms, alg) {
+		return nil, fmt.Errorf(
+			"bucketupload: DigestAlgorithm: invalid value %q, must be one of (%s)",
+			alg,
+			strings.Join(digestAlgorithms, ", "),
+		)
+	}
 
-	file, err := os.Open(params.Path)
-	if err != nil {
-		return nil, fmt.Errorf("bucketupload: open file: %w", err)
+	if err := params.Policy.validate(); err != nil {
+		return nil, fmt.Errorf("bucketupload: Policy: %v", err)
 	}
-	defer file.Close()
 
 	key := filepath.Base(params.Path)
 	if params.Key != "" {
 		key = params.Key
 	}
 
-	opts := &blob.WriterOptions{
-		ContentType: "application/octet-stream",
-		BufferSize:  params.BufferSize,
+	if fi, err := os.Stat(params.Path); err == nil && fi.IsDir() {
+		h := temporal.StartAutoHeartbeat(ctx)
+		defer h.Stop()
+
+		objects, err := a.uploadDir(ctx, params.Path, key, alg, params)
+		if err != nil {
+			return nil, uploadError(err)
+		}
+
+		res := &Result{Key: key, DigestAlgorithm: alg, Objects: objects}
+		for _, o := range objects {
+			res.Size += o.Size
+		}
+
+		return res, nil
+	}
+
+	var (
+		o   *Object
+		err error
+	)
+	if params.PartSize > 0 {
+		// Staged uploads record their progress in the heartbeat details.
+		o, err = a.uploadStaged(ctx, params.Path, key, alg, params)
+	} else {
+		h := temporal.StartAutoHeartbeat(ctx)
+		defer h.Stop()
+
+		o, err = a.uploadFile(ctx, params.Path, key, alg, params)
+	}
+	if err != nil {
+		return nil, uploadError(err)
+	}
+
+	return &Result{
+		Key:             o.Key,
+		Size:            o.Size,
+		MD5:             o.MD5,
+		Digest:          o.Digest,
+		DigestAlgorithm: alg,
+		ContentType:     o.ContentType,
+		Outcome:         o.Outcome,
+	}, nil
+}
+
+// uploadError wraps err, making it non-retryable if the upload failed because
+// the object exists.
+func uploadError(err error) error {
+	err = fmt.Errorf("bucketupload: %w", err)
+	if errors.Is(err, ErrExists) {
+		return temporalsdk_temporal.NewNonRetryableApplicationError(err.Error(), "ObjectExists", err)
+	}
+
+	return err
+}
+
+// uploadDir uploads the files in the dir tree concurrently, using prefix
+// joined with their relative paths as keys.
+func (a *Activity) uploadDir(ctx context.Context, dir, prefix, alg string, params *Params) ([]Object, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read dir: %w", err)
+	}
+
+	concurrency := defaultConcurrency
+	if params.Concurrency > 0 {
+		concurrency = params.Concurrency
+	}
+
+	objects := make([]Object, len(paths))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+	for i, p := range paths {
+		g.Go(func() error {
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+
+			o, err := a.uploadFile(gctx, p, path.Join(prefix, filepath.ToSlash(rel)), alg, params)
+			if err != nil {
+				return fmt.Errorf("%s: %w", rel, err)
+			}
+			objects[i] = *o
+
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(objects, func(a, b Object) int { return strings.Compare(a.Key, b.Key) })
+
+	return objects, nil
+}
+
+// uploadFile uploads the file at p to key, checking the integrity of the
+// stored object.
+func (a *Activity) uploadFile(ctx context.Context, p, key, alg string, params *Params) (*Object, error) {
+	file, err := os.Open(p) // #nosec G304 -- trusted file path.
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+
+	// Generate the checksums before uploading small files, and files that may
+	// be identical to the existing object, so the bucket writer and the
+	// provider check the uploaded content against ContentMD5. Bigger files
+	// are only read once, hashed while they are uploaded.
+	var sums *digests
+	if fi.Size() <= preHashMaxSize || params.Policy == PolicySkipIfIdentical {
+		if sums, err = checksum(file, alg); err != nil {
+			return nil, fmt.Errorf("checksum file: %w", err)
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("checksum file: %w", err)
+		}
+	}
+
+	opts, err := writerOptions(file, params)
+	if err != nil {
+		return nil, fmt.Errorf("detect content type: %w", err)
+	}
+
+	outcome, err := a.applyPolicy(ctx, key, sums, params.Policy)
+	if err != nil {
+		return nil, err
+	}
+	if outcome == OutcomeSkipped {
+		return newObject(key, sums, opts, outcome), nil
+	}
+	// Guard against the object being created after checking it.
+	opts.IfNotExist = params.Policy == PolicyFailIfExists
+
+	if sums != nil {
+		opts.ContentMD5 = sums.md5
+		err = a.bucket.Upload(ctx, key, file, opts)
+	} else {
+		sums, err = a.uploadHashed(ctx, key, file, alg, opts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("upload file: %w", existsError(err, key, opts))
+	}
+
+	if err := a.verify(ctx, key, sums); err != nil {
+		return nil, fmt.Errorf("verify upload: %w", err)
+	}
+
+	return newObject(key, sums, opts, outcome), nil
+}
+
+// uploadHashed uploads r to key using opts, returning the checksums of the
+// uploaded content. The object isn't written if r can't be read.
+func (a *Activity) uploadHashed(
+	ctx context.Context,
+	key string,
+	r io.Reader,
+	alg string,
+	opts *blob.WriterOptions,
+) (*digests, error) {
+	// Cancelling the writer context discards the object on Close.
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w, err := a.bucket.NewWriter(wctx, key, opts)
+	if err != nil {
+		return nil, err
+	}
+	sums, err := checksum(io.TeeReader(r, w), alg)
+	if err != nil {
+		cancel()
+		_ = w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return sums, nil
+}
+
+// newObject returns the Object uploaded to key.
+func newObject(key string, sums *digests, opts *blob.WriterOptions, outcome Outcome) *Object {
+	return &Object{
+		Key:         key,
+		Size:        sums.size,
+		MD5:         hex.EncodeToString(sums.md5),
+		Digest:      sums.digest,
+		ContentType: opts.ContentType,
+		Outcome:     outcome,
+	}
+}
+
+// verify checks that the size and MD5 of the stored object at key match the
+// uploaded file. The MD5 is only checked if the provider returns it.
+func (a *Activity) verify(ctx context.Context, key string, sums *digests) error {
+	attrs, err := a.bucket.Attributes(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if attrs.Size != sums.size {
+		return fmt.Errorf("size mismatch: expected %d bytes, got %d bytes", sums.size, attrs.Size)
 	}
-	if err = a.bucket.Upload(ctx, key, file, opts); err != nil {
-		return nil, fmt.Errorf("bucketupload: upload file: %w", err)
+	if len(attrs.MD5) > 0 && !bytes.Equal(attrs.MD5, sums.md5) {
+		return fmt.Errorf(
+			"MD5 mismatch: expected %s, got %s",
+			hex.EncodeToString(sums.md5),
+			hex.EncodeToString(attrs.MD5),
+		)
 	}
 
-	return &Result{Key: key}, nil
+	return nil
 }
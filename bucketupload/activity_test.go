@@ -1,11 +1,22 @@
 package bucketupload_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	temporalsdk_activity "go.temporal.io/sdk/activity"
+	temporalsdk_temporal "go.temporal.io/sdk/temporal"
 	temporalsdk_testsuite "go.temporal.io/sdk/testsuite"
 	"gocloud.dev/blob"
 	"gocloud.dev/blob/memblob"
@@ -15,6 +26,12 @@ import (
 	"github.com/artefactual-sdps/temporal-activities/bucketupload"
 )
 
+const (
+	contentMD5    = "9a0364b9e99bb480dd25e1f0284c8555"
+	contentSHA256 = "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73"
+	contentSHA512 = "b2d1d285b5199c85f988d03649c37e44fd3dde01e5d69c50fef90651962f48110e9340b60d49a479c4c0b53f5f07d690686dd87d2481937a512e8b85ee7c617f"
+)
+
 func bucket(t *testing.T) *blob.Bucket {
 	t.Helper()
 
@@ -24,9 +41,25 @@ func bucket(t *testing.T) *blob.Bucket {
 	return b
 }
 
+// hashState returns the encoded state of h after writing s.
+func hashState(t *testing.T, h hash.Hash, s string) []byte {
+	t.Helper()
+
+	_, _ = io.WriteString(h, s)
+	state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	assert.NilError(t, err)
+
+	return state
+}
+
 func TestActivity(t *testing.T) {
 	t.Parallel()
 
+	// big is hashed while it's uploaded, without a ContentMD5 check.
+	big := bytes.Repeat([]byte("content"), 5<<20)
+	bigMD5 := md5.Sum(big)
+	bigSHA256 := sha256.Sum256(big)
+
 	tests := []struct {
 		name        string
 		bucket      *blob.Bucket
@@ -45,7 +78,15 @@ func TestActivity(t *testing.T) {
 				),
 				Key: "changed.txt",
 			},
-			wantRes: bucketupload.Result{Key: "changed.txt"},
+			wantRes: bucketupload.Result{
+				Key:             "changed.txt",
+				Size:            7,
+				MD5:             contentMD5,
+				Digest:          contentSHA256,
+				DigestAlgorithm: "sha256",
+				ContentType:     "application/octet-stream",
+				Outcome:         bucketupload.OutcomeUploaded,
+			},
 		},
 		{
 			name:   "Uploads a file using name as key",
@@ -56,7 +97,112 @@ func TestActivity(t *testing.T) {
 					"file.txt",
 				),
 			},
-			wantRes: bucketupload.Result{Key: "file.txt"},
+			wantRes: bucketupload.Result{
+				Key:             "file.txt",
+				Size:            7,
+				MD5:             contentMD5,
+				Digest:          contentSHA256,
+				DigestAlgorithm: "sha256",
+				ContentType:     "application/octet-stream",
+				Outcome:         bucketupload.OutcomeUploaded,
+			},
+		},
+		{
+			name:   "Uploads a file with a sha512 digest",
+			bucket: bucket(t),
+			params: bucketupload.Params{
+				Path: filepath.Join(
+					fs.NewDir(t, "bucketupload_test", fs.WithFile("file.txt", "content")).Path(),
+					"file.txt",
+				),
+				DigestAlgorithm: "sha512",
+				ContentType:     "application/octet-stream",
+			},
+			wantRes: bucketupload.Result{
+				Key:             "file.txt",
+				Size:            7,
+				MD5:             contentMD5,
+				Digest:          contentSHA512,
+				DigestAlgorithm: "sha512",
+				ContentType:     "application/octet-stream",
+				Outcome:         bucketupload.OutcomeUploaded,
+			},
+		},
+		{
+			name:   "Uploads a big file hashing it while uploading",
+			bucket: bucket(t),
+			params: bucketupload.Params{
+				Path: filepath.Join(
+					fs.NewDir(t, "bucketupload_test", fs.WithFile("big.bin", "", fs.WithBytes(big))).Path(),
+					"big.bin",
+				),
+			},
+			wantRes: bucketupload.Result{
+				Key:             "big.bin",
+				Size:            int64(len(big)),
+				MD5:             hex.EncodeToString(bigMD5[:]),
+				Digest:          hex.EncodeToString(bigSHA256[:]),
+				DigestAlgorithm: "sha256",
+				ContentType:     "application/octet-stream",
+				Outcome:         bucketupload.OutcomeUploaded,
+			},
+		},
+		{
+			name:   "Fails with an invalid digest algorithm",
+			bucket: bucket(t),
+			params: bucketupload.Params{
+				Path: filepath.Join(
+					fs.NewDir(t, "bucketupload_test", fs.WithFile("file.txt", "content")).Path(),
+					"file.txt",
+				),
+				DigestAlgorithm: "md5",
+			},
+			wantErr: `bucketupload: DigestAlgorithm: invalid value "md5", must be one of (sha256, sha512)`,
+		},
+		{
+			name:   "Uploads a directory",
+			bucket: bucket(t),
+			params: bucketupload.Params{
+				Path: fs.NewDir(t, "bucketupload_test",
+					fs.WithFile("file.txt", "content"),
+					fs.WithDir("dir", fs.WithFile("file.txt", "content"), fs.WithDir("empty")),
+				).Path(),
+				Key:         "prefix",
+				Concurrency: 1,
+			},
+			wantRes: bucketupload.Result{
+				Key:             "prefix",
+				Size:            14,
+				DigestAlgorithm: "sha256",
+				Objects: []bucketupload.Object{
+					{
+						Key:         "prefix/dir/file.txt",
+						Size:        7,
+						MD5:         contentMD5,
+						Digest:      contentSHA256,
+						ContentType: "application/octet-stream",
+						Outcome:     bucketupload.OutcomeUploaded,
+					},
+					{
+						Key:         "prefix/file.txt",
+						Size:        7,
+						MD5:         contentMD5,
+						Digest:      contentSHA256,
+						ContentType: "application/octet-stream",
+						Outcome:     bucketupload.OutcomeUploaded,
+					},
+				},
+			},
+		},
+		{
+			name:        "Fails to upload a directory to a closed bucket",
+			bucket:      bucket(t),
+			closeBucket: true,
+			params: bucketupload.Params{
+				Path: fs.NewDir(t, "bucketupload_test", fs.WithFile("file.txt", "content")).Path(),
+				Key:  "prefix",
+			},
+			wantErr: "bucketupload: file.txt: check existing object:",
 		},
 		{
 			name:   "Fails to upload a missing file",
@@ -76,7 +222,7 @@ func TestActivity(t *testing.T) {
 					"file.txt",
 				),
 			},
-			wantErr: "bucketupload: upload file:",
+			wantErr: "bucketupload: check existing object:",
 		},
 	}
 	for _, tt := range tests {
@@ -106,9 +252,356 @@ func TestActivity(t *testing.T) {
 			_ = enc.Get(&result)
 			assert.DeepEqual(t, result, tt.wantRes)
 
-			exists, err := tt.bucket.Exists(context.Background(), tt.wantRes.Key)
+			objects := tt.wantRes.Objects
+			if objects == nil {
+				objects = []bucketupload.Object{{Key: tt.wantRes.Key, MD5: tt.wantRes.MD5}}
+			}
+			for _, o := range objects {
+				attrs, err := tt.bucket.Attributes(context.Background(), o.Key)
+				assert.NilError(t, err)
+				assert.Equal(t, hex.EncodeToString(attrs.MD5), o.MD5)
+			}
+		})
+	}
+}
+
+func TestActivityStaged(t *testing.T) {
+	t.Parallel()
+
+	// The staged parts of a resumed upload are not uploaded again, so the
+	// object has the staged content instead of the file content. The details
+	// ModTime defaults to the file modification time.
+	type test struct {
+		name    string
+		staged  map[string]string
+		details *bucketupload.Progress
+		want    string
+	}
+	for _, tt := range []test{
+		{
+			name: "Uploads a file in parts",
+			want: "content",
+		},
+		{
+			name:   "Resumes an upload from the last staged part",
+			staged: map[string]string{"file.txt.parts/upload/000001": "XYZ"},
+			details: &bucketupload.Progress{
+				Key:         "file.txt",
+				UploadID:    "upload",
+				FileSize:    7,
+				PartSize:    3,
+				Parts:       1,
+				Offset:      3,
+				MD5State:    hashState(t, md5.New(), "XYZ"),
+				DigestState: hashState(t, sha256.New(), "XYZ"),
+			},
+			want: "XYZtent",
+		},
+		{
+			name: "Composes the staged parts of an upload",
+			staged: map[string]string{
+				"file.txt.parts/upload/000001": "XYZ",
+				"file.txt.parts/upload/000002": "ten",
+				"file.txt.parts/upload/000003": "t",
+			},
+			details: &bucketupload.Progress{
+				Key:         "file.txt",
+				UploadID:    "upload",
+				FileSize:    7,
+				PartSize:    3,
+				Parts:       3,
+				Offset:      7,
+				MD5State:    hashState(t, md5.New(), "XYZtent"),
+				DigestState: hashState(t, sha256.New(), "XYZtent"),
+			},
+			want: "XYZtent",
+		},
+		{
+			name: "Uploads again the parts that are not staged",
+			staged: map[string]string{
+				"file.txt.parts/upload/000001": "con",
+				"file.txt.parts/upload/000002": "te",
+			},
+			details: &bucketupload.Progress{
+				Key:      "file.txt",
+				UploadID: "upload",
+				FileSize: 7,
+				PartSize: 3,
+				Parts:    2,
+				Offset:   6,
+			},
+			want: "content",
+		},
+		{
+			name:   "Starts a new upload if the file size changed",
+			staged: map[string]string{"file.txt.parts/upload/000001": "XYZ"},
+			details: &bucketupload.Progress{
+				Key:         "file.txt",
+				UploadID:    "upload",
+				FileSize:    8,
+				PartSize:    3,
+				Parts:       1,
+				Offset:      3,
+				MD5State:    hashState(t, md5.New(), "XYZ"),
+				DigestState: hashState(t, sha256.New(), "XYZ"),
+			},
+			want: "content",
+		},
+		{
+			name:   "Starts a new upload if the file was modified",
+			staged: map[string]string{"file.txt.parts/upload/000001": "XYZ"},
+			details: &bucketupload.Progress{
+				Key:         "file.txt",
+				UploadID:    "upload",
+				FileSize:    7,
+				ModTime:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+				PartSize:    3,
+				Parts:       1,
+				Offset:      3,
+				MD5State:    hashState(t, md5.New(), "XYZ"),
+				DigestState: hashState(t, sha256.New(), "XYZ"),
+			},
+			want: "content",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(
+				fs.NewDir(t, "bucketupload_test", fs.WithFile("file.txt", "content")).Path(),
+				"file.txt",
+			)
+			fi, err := os.Stat(path)
+			assert.NilError(t, err)
+
+			b := bucket(t)
+			for key, content := range tt.staged {
+				assert.NilError(t, b.WriteAll(context.Background(), key, []byte(content), nil))
+			}
+
+			ts := &temporalsdk_testsuite.WorkflowTestSuite{}
+			env := ts.NewTestActivityEnvironment()
+			if tt.details != nil {
+				details := *tt.details
+				if details.ModTime.IsZero() {
+					details.ModTime = fi.ModTime()
+				}
+				env.SetHeartbeatDetails(details)
+			}
+			env.RegisterActivityWithOptions(
+				bucketupload.New(b).Execute,
+				temporalsdk_activity.RegisterOptions{Name: bucketupload.Name},
+			)
+
+			enc, err := env.ExecuteActivity(bucketupload.Name, bucketupload.Params{
+				Path:     path,
+				PartSize: 3,
+			})
+			assert.NilError(t, err)
+
+			wantMD5 := md5.Sum([]byte(tt.want))
+			wantSHA256 := sha256.Sum256([]byte(tt.want))
+			var result bucketupload.Result
+			_ = enc.Get(&result)
+			assert.DeepEqual(t, result, bucketupload.Result{
+				Key:             "file.txt",
+				Size:            7,
+				MD5:             hex.EncodeToString(wantMD5[:]),
+				Digest:          hex.EncodeToString(wantSHA256[:]),
+				DigestAlgorithm: "sha256",
+				ContentType:     "application/octet-stream",
+				Outcome:         bucketupload.OutcomeUploaded,
+			})
+
+			got, err := b.ReadAll(context.Background(), "file.txt")
 			assert.NilError(t, err)
-			assert.Assert(t, exists)
+			assert.Equal(t, string(got), tt.want)
+
+			// The staged parts, including the abandoned ones, are deleted.
+			iter := b.List(&blob.ListOptions{Prefix: "file.txt.parts/"})
+			_, err = iter.Next(context.Background())
+			assert.Equal(t, err, io.EOF)
+		})
+	}
+}
+
+func TestActivityAttributes(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		name      string
+		file      string
+		content   string
+		params    bucketupload.Params
+		wantAttrs blob.Attributes
+	}
+	for _, tt := range []test{
+		{
+			name:    "Sets the object attributes",
+			file:    "file.txt",
+			content: "content",
+			params: bucketupload.Params{
+				ContentType:        "application/x-custom",
+				DetectContentType:  true,
+				ContentDisposition: `attachment; filename="file.txt"`,
+				CacheControl:       "no-cache",
+				Metadata:           map[string]string{"package-id": "1234", "source": "transfer"},
+			},
+			wantAttrs: blob.Attributes{
+				ContentType:        "application/x-custom",
+				ContentDisposition: `attachment; filename="file.txt"`,
+				CacheControl:       "no-cache",
+				Metadata:           map[string]string{"package-id": "1234", "source": "transfer"},
+			},
+		},
+		{
+			name:      "Detects the content type from the file extension",
+			file:      "file.txt",
+			content:   "<html></html>",
+			params:    bucketupload.Params{DetectContentType: true},
+			wantAttrs: blob.Attributes{ContentType: "text/plain; charset=utf-8"},
+		},
+		{
+			name:      "Detects the content type from the file content",
+			file:      "file",
+			content:   "<html></html>",
+			params:    bucketupload.Params{DetectContentType: true},
+			wantAttrs: blob.Attributes{ContentType: "text/html; charset=utf-8"},
+		},
+		{
+			name:      "Uses a default content type",
+			file:      "file.txt",
+			content:   "content",
+			wantAttrs: blob.Attributes{ContentType: "application/octet-stream"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			b := bucket(t)
+			ts := &temporalsdk_testsuite.WorkflowTestSuite{}
+			env := ts.NewTestActivityEnvironment()
+			env.RegisterActivityWithOptions(
+				bucketupload.New(b).Execute,
+				temporalsdk_activity.RegisterOptions{Name: bucketupload.Name},
+			)
+
+			params := tt.params
+			params.Path = filepath.Join(
+				fs.NewDir(t, "bucketupload_test", fs.WithFile(tt.file, tt.content)).Path(),
+				tt.file,
+			)
+			enc, err := env.ExecuteActivity(bucketupload.Name, params)
+			assert.NilError(t, err)
+
+			var result bucketupload.Result
+			_ = enc.Get(&result)
+			assert.Equal(t, result.ContentType, tt.wantAttrs.ContentType)
+
+			attrs, err := b.Attributes(context.Background(), tt.file)
+			assert.NilError(t, err)
+			assert.Equal(t, attrs.ContentType, tt.wantAttrs.ContentType)
+			assert.Equal(t, attrs.ContentDisposition, tt.wantAttrs.ContentDisposition)
+			assert.Equal(t, attrs.CacheControl, tt.wantAttrs.CacheControl)
+			assert.DeepEqual(t, attrs.Metadata, tt.wantAttrs.Metadata)
+		})
+	}
+}
+
+func TestActivityPolicy(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		name         string
+		existing     string
+		params       bucketupload.Params
+		wantOutcome  bucketupload.Outcome
+		wantContent  string
+		wantErr      string
+		nonRetryable bool
+	}
+	for _, tt := range []test{
+		{
+			name:        "Uploads a new object",
+			params:      bucketupload.Params{Policy: bucketupload.PolicyFailIfExists},
+			wantOutcome: bucketupload.OutcomeUploaded,
+			wantContent: "content",
+		},
+		{
+			name:        "Overwrites an existing object by default",
+			existing:    "old content",
+			wantOutcome: bucketupload.OutcomeOverwritten,
+			wantContent: "content",
+		},
+		{
+			name:         "Fails if the object exists",
+			existing:     "old content",
+			params:       bucketupload.Params{Policy: bucketupload.PolicyFailIfExists},
+			wantContent:  "old content",
+			wantErr:      "bucketupload: object already exists: file.txt",
+			nonRetryable: true,
+		},
+		{
+			name:        "Skips an identical object",
+			existing:    "content",
+			params:      bucketupload.Params{Policy: bucketupload.PolicySkipIfIdentical},
+			wantOutcome: bucketupload.OutcomeSkipped,
+			wantContent: "content",
+		},
+		{
+			name:        "Overwrites a different object",
+			existing:    "old content",
+			params:      bucketupload.Params{Policy: bucketupload.PolicySkipIfIdentical},
+			wantOutcome: bucketupload.OutcomeOverwritten,
+			wantContent: "content",
+		},
+		{
+			name:    "Fails with an invalid policy",
+			params:  bucketupload.Params{Policy: "ignore"},
+			wantErr: `bucketupload: Policy: invalid value "ignore", must be one of (overwrite, fail-if-exists, skip-if-identical)`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			b := bucket(t)
+			if tt.existing != "" {
+				err := b.WriteAll(context.Background(), "file.txt", []byte(tt.existing), nil)
+				assert.NilError(t, err)
+			}
+
+			ts := &temporalsdk_testsuite.WorkflowTestSuite{}
+			env := ts.NewTestActivityEnvironment()
+			env.RegisterActivityWithOptions(
+				bucketupload.New(b).Execute,
+				temporalsdk_activity.RegisterOptions{Name: bucketupload.Name},
+			)
+
+			params := tt.params
+			params.Path = filepath.Join(
+				fs.NewDir(t, "bucketupload_test", fs.WithFile("file.txt", "content")).Path(),
+				"file.txt",
+			)
+			enc, err := env.ExecuteActivity(bucketupload.Name, params)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+
+				var appErr *temporalsdk_temporal.ApplicationError
+				assert.Assert(t, errors.As(err, &appErr))
+				assert.Equal(t, appErr.NonRetryable(), tt.nonRetryable)
+			} else {
+				assert.NilError(t, err)
+
+				var result bucketupload.Result
+				_ = enc.Get(&result)
+				assert.Equal(t, result.Outcome, tt.wantOutcome)
+			}
+
+			if tt.wantContent != "" {
+				got, err := b.ReadAll(context.Background(), "file.txt")
+				assert.NilError(t, err)
+				assert.Equal(t, string(got), tt.wantContent)
+			}
 		})
 	}
 }
@@ -0,0 +1,125 @@
+package bucketupload
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	azblob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"gocloud.dev/blob"
+)
+
+// azureMaxBlocks is the maximum number of blocks of an Azure block blob.
+const azureMaxBlocks = 50_000
+
+// azureStager stages the parts of an upload to a key as uncommitted blocks of
+// its blob, committed in the bucket without reading them back.
+type azureStager struct {
+	client *blockblob.Client
+	key    string
+
+	// upload has the options the driver uses to write the key, with the
+	// blob HTTP headers and metadata.
+	upload *blockblob.UploadStreamOptions
+}
+
+func (s *azureStager) start(_ context.Context, p Progress) (string, error) {
+	if p.FileSize > azureMaxBlocks*p.PartSize {
+		return "", fmt.Errorf("the file can't be uploaded to Azure in more than %d parts", azureMaxBlocks)
+	}
+
+	return rand.Text(), nil
+}
+
+// blockID returns the ID of the block of the part number of the upload id.
+// The IDs of all the blocks of a blob must have the same length.
+func blockID(id string, number int) string {
+	return base64.StdEncoding.EncodeToString(fmt.Appendf(nil, "%s-%06d", id, number))
+}
+
+func (s *azureStager) staged(ctx context.Context, p Progress) (int, error) {
+	resp, err := s.client.GetBlockList(ctx, blockblob.BlockListTypeUncommitted, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	sizes := make(map[string]int64)
+	for _, b := range resp.UncommittedBlocks {
+		if b.Name != nil && b.Size != nil {
+			sizes[*b.Name] = *b.Size
+		}
+	}
+	for number := 1; number <= p.Parts; number++ {
+		size, ok := sizes[blockID(p.UploadID, number)]
+		if !ok || size != p.partSize(number) {
+			return number - 1, nil
+		}
+	}
+
+	return p.Parts, nil
+}
+
+func (s *azureStager) stagePart(
+	ctx context.Context,
+	p Progress,
+	number int,
+	r io.ReadSeeker,
+	_ int64,
+	md5 []byte,
+) error {
+	_, err := s.client.StageBlock(ctx, blockID(p.UploadID, number), streaming.NopCloser(r), &blockblob.StageBlockOptions{
+		TransactionalValidation: azblob.TransferValidationTypeMD5(md5),
+	})
+
+	return err
+}
+
+// compose commits the staged blocks, setting the blob MD5 checksum to
+// opts.ContentMD5.
+func (s *azureStager) compose(ctx context.Context, p Progress, opts *blob.WriterOptions) error {
+	ids := make([]string, p.Parts)
+	for i := range ids {
+		ids[i] = blockID(p.UploadID, i+1)
+	}
+
+	var headers azblob.HTTPHeaders
+	if s.upload.HTTPHeaders != nil {
+		headers = *s.upload.HTTPHeaders
+	}
+	headers.BlobContentMD5 = opts.ContentMD5
+
+	commitOpts := &blockblob.CommitBlockListOptions{
+		HTTPHeaders: &headers,
+		Metadata:    s.upload.Metadata,
+		Tier:        s.upload.AccessTier,
+	}
+	if opts.IfNotExist {
+		commitOpts.AccessConditions = &azblob.AccessConditions{
+			ModifiedAccessConditions: &azblob.ModifiedAccessConditions{IfNoneMatch: to.Ptr(azcore.ETagAny)},
+		}
+	}
+	if _, err := s.client.CommitBlockList(ctx, ids, commitOpts); err != nil {
+		if opts.IfNotExist && bloberror.HasCode(err, bloberror.ConditionNotMet, bloberror.BlobAlreadyExists) {
+			return fmt.Errorf("%w: %s", ErrExists, s.key)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// discard does nothing, Azure deletes the uncommitted blocks of a blob when a
+// block list is committed, or after a week.
+func (s *azureStager) discard(context.Context) error {
+	return nil
+}
@@ -0,0 +1,53 @@
+package bucketupload
+
+import (
+	"crypto/md5" // #nosec G501 -- MD5 is used for the ContentMD5 integrity check.
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// digestAlgorithms lists the supported digest algorithms.
+var digestAlgorithms = []string{"sha256", "sha512"}
+
+// digests is the MD5 and strong digest of a file.
+type digests struct {
+	size   int64
+	md5    []byte
+	digest string
+}
+
+// newDigestHash returns a hash.Hash for a supported digest algorithm.
+func newDigestHash(alg string) hash.Hash {
+	if alg == "sha512" {
+		return sha512.New()
+	}
+	return sha256.New()
+}
+
+// checksum reads r, generating its size, MD5 and the digest with the given
+// algorithm in a single pass. The digest is not generated if alg is empty.
+func checksum(r io.Reader, alg string) (*digests, error) {
+	md5h := md5.New() // #nosec G401 -- MD5 is used for the ContentMD5 integrity check.
+	w := io.Writer(md5h)
+
+	var h hash.Hash
+	if alg != "" {
+		h = newDigestHash(alg)
+		w = io.MultiWriter(md5h, h)
+	}
+
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &digests{size: n, md5: md5h.Sum(nil)}
+	if h != nil {
+		d.digest = hex.EncodeToString(h.Sum(nil))
+	}
+
+	return d, nil
+}
@@ -0,0 +1,69 @@
+package bucketupload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"gocloud.dev/blob"
+	"google.golang.org/api/googleapi"
+)
+
+// gcsMaxComposeSources is the maximum number of objects composed at once.
+const gcsMaxComposeSources = 32
+
+// gcsStager stages the parts of an upload to key as objects, like
+// objectStager, and composes them in the bucket without reading them back.
+type gcsStager struct {
+	*objectStager
+	bucket *storage.BucketHandle
+
+	// name is the name of the key object in the bucket, escaped by the
+	// driver.
+	name string
+}
+
+// object returns the handle of the staged object at key.
+func (s *gcsStager) object(key string) *storage.ObjectHandle {
+	return s.bucket.Object(s.name + strings.TrimPrefix(key, s.key))
+}
+
+// compose composes the staged parts into the object, in batches of at most
+// gcsMaxComposeSources objects composed into an intermediate object, staged as
+// part 0. The composed object has no MD5 checksum, so opts.ContentMD5 is not
+// checked.
+func (s *gcsStager) compose(ctx context.Context, p Progress, opts *blob.WriterOptions) error {
+	tmp := s.object(partKey(s.key, p.UploadID, 0))
+	srcs := make([]*storage.ObjectHandle, 0, gcsMaxComposeSources)
+	for number := 1; number <= p.Parts; number++ {
+		srcs = append(srcs, s.object(partKey(s.key, p.UploadID, number)))
+		if len(srcs) == gcsMaxComposeSources && number < p.Parts {
+			if _, err := tmp.ComposerFrom(srcs...).Run(ctx); err != nil {
+				return fmt.Errorf("compose part %d: %w", number, err)
+			}
+			srcs = append(srcs[:0], tmp)
+		}
+	}
+
+	dst := s.bucket.Object(s.name)
+	if opts.IfNotExist {
+		dst = dst.If(storage.Conditions{DoesNotExist: true})
+	}
+	c := dst.ComposerFrom(srcs...)
+	c.ContentType = opts.ContentType
+	c.ContentDisposition = opts.ContentDisposition
+	c.CacheControl = opts.CacheControl
+	c.Metadata = opts.Metadata
+	if _, err := c.Run(ctx); err != nil {
+		var gerr *googleapi.Error
+		if opts.IfNotExist && errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed {
+			return fmt.Errorf("%w: %s", ErrExists, s.key)
+		}
+		return err
+	}
+
+	return nil
+}
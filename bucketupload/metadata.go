@@ -0,0 +1,55 @@
+package bucketupload
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"gocloud.dev/blob"
+)
+
+// defaultContentType is the content type of the uploaded objects when it's
+// not set or detected.
+const defaultContentType = "application/octet-stream"
+
+// writerOptions returns the options to upload file, with the object content
+// type, metadata and buffer size from params.
+func writerOptions(file *os.File, params *Params) (*blob.WriterOptions, error) {
+	contentType := params.ContentType
+	if contentType == "" && params.DetectContentType {
+		var err error
+		if contentType, err = detectContentType(file); err != nil {
+			return nil, err
+		}
+	}
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+
+	return &blob.WriterOptions{
+		ContentType:        contentType,
+		ContentDisposition: params.ContentDisposition,
+		CacheControl:       params.CacheControl,
+		Metadata:           params.Metadata,
+		BufferSize:         params.BufferSize,
+	}, nil
+}
+
+// detectContentType returns the content type of file based on its extension
+// or, if unknown, on its first 512 bytes.
+func detectContentType(file *os.File) (string, error) {
+	if t := mime.TypeByExtension(filepath.Ext(file.Name())); t != "" {
+		return t, nil
+	}
+
+	buf := make([]byte, 512)
+	n, err := file.ReadAt(buf, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	return http.DetectContentType(buf[:n]), nil
+}
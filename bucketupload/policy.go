@@ -0,0 +1,101 @@
+package bucketupload
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// Policy sets how an upload handles an existing object with the same key.
+type Policy string
+
+const (
+	// PolicyOverwrite overwrites the existing object. It's the default
+	// policy.
+	PolicyOverwrite Policy = "overwrite"
+
+	// PolicyFailIfExists fails the upload if the object exists.
+	PolicyFailIfExists Policy = "fail-if-exists"
+
+	// PolicySkipIfIdentical skips the upload if the existing object has the
+	// same size and checksum as the file, and overwrites it otherwise.
+	PolicySkipIfIdentical Policy = "skip-if-identical"
+)
+
+// policies lists the valid upload policies.
+var policies = []Policy{PolicyOverwrite, PolicyFailIfExists, PolicySkipIfIdentical}
+
+// Outcome is the result of applying the upload Policy.
+type Outcome string
+
+const (
+	// OutcomeUploaded means that the object didn't exist and was uploaded.
+	OutcomeUploaded Outcome = "uploaded"
+
+	// OutcomeOverwritten means that an existing object was overwritten.
+	OutcomeOverwritten Outcome = "overwritten"
+
+	// OutcomeSkipped means that an identical object existed and the upload
+	// was skipped.
+	OutcomeSkipped Outcome = "skipped"
+)
+
+// ErrExists is returned when the object exists and the PolicyFailIfExists
+// policy is used.
+var ErrExists = errors.New("object already exists")
+
+// validate returns an error if p is not a valid upload policy.
+func (p Policy) validate() error {
+	if p == "" || slices.Contains(policies, p) {
+		return nil
+	}
+
+	names := make([]string, len(policies))
+	for i, p := range policies {
+		names[i] = string(p)
+	}
+
+	return fmt.Errorf("invalid value %q, must be one of (%s)", string(p), strings.Join(names, ", "))
+}
+
+// applyPolicy checks the existing object at key, returning the upload outcome
+// if the upload goes ahead, or OutcomeSkipped if it must be skipped. It
+// returns ErrExists if the object exists and policy is PolicyFailIfExists.
+// Objects are only identical if the bucket returns their MD5 checksum, so
+// PolicySkipIfIdentical overwrites objects without one.
+func (a *Activity) applyPolicy(ctx context.Context, key string, sums *digests, policy Policy) (Outcome, error) {
+	attrs, err := a.bucket.Attributes(ctx, key)
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return OutcomeUploaded, nil
+		}
+		return "", fmt.Errorf("check existing object: %w", err)
+	}
+
+	switch policy {
+	case PolicyFailIfExists:
+		return "", fmt.Errorf("%w: %s", ErrExists, key)
+	case PolicySkipIfIdentical:
+		if attrs.Size == sums.size && len(attrs.MD5) > 0 && bytes.Equal(attrs.MD5, sums.md5) {
+			return OutcomeSkipped, nil
+		}
+	}
+
+	return OutcomeOverwritten, nil
+}
+
+// existsError returns ErrExists if err is caused by the object at key being
+// created during an upload with the IfNotExist option, or err otherwise.
+func existsError(err error, key string, opts *blob.WriterOptions) error {
+	if opts.IfNotExist && gcerrors.Code(err) == gcerrors.FailedPrecondition {
+		return fmt.Errorf("%w: %s", ErrExists, key)
+	}
+
+	return err
+}
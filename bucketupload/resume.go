@@ -0,0 +1,331 @@
+package bucketupload
+
+import (
+	"context"
+	"crypto/md5" // #nosec G501 -- MD5 is used for the ContentMD5 integrity check.
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"time"
+
+	temporalsdk_activity "go.temporal.io/sdk/activity"
+
+	"github.com/artefactual-sdps/temporal-activities/internal/heartbeat"
+)
+
+// Progress is the progress of a staged upload, recorded in the activity
+// heartbeat details to resume the upload when the activity is retried.
+type Progress struct {
+	// Key of the uploaded object.
+	Key string
+
+	// UploadID identifies the staged parts of the upload.
+	UploadID string
+
+	// FileSize is the size in bytes of the uploaded file.
+	FileSize int64
+
+	// ModTime is the modification time of the uploaded file. The upload is
+	// only resumed if the file size and modification time didn't change.
+	ModTime time.Time
+
+	// PartSize is the size in bytes of the staged parts.
+	PartSize int64
+
+	// Parts is the number of the last part staged, all the previous parts
+	// are staged too.
+	Parts int
+
+	// Offset is the number of bytes of the file staged.
+	Offset int64
+
+	// MD5State and DigestState are the encoded states of the file MD5 and
+	// digest hashes after Offset bytes, so the staged bytes are not read
+	// again when the upload is resumed.
+	MD5State    []byte
+	DigestState []byte
+}
+
+// partSize returns the expected size in bytes of the part number.
+func (p Progress) partSize(number int) int64 {
+	return min(p.PartSize, p.FileSize-int64(number-1)*p.PartSize)
+}
+
+// partsPrefix returns the key prefix of the staged parts of the uploads to
+// key.
+func partsPrefix(key string) string {
+	return key + ".parts/"
+}
+
+// partKey returns the key of a staged part of the upload to key.
+func partKey(key, uploadID string, number int) string {
+	return fmt.Sprintf("%s%s/%06d", partsPrefix(key), uploadID, number)
+}
+
+// uploadStaged uploads the file at p to key as staged parts of
+// params.PartSize bytes, generating the file checksums while the parts are
+// uploaded. The progress is recorded in the activity heartbeats, and a
+// previous upload of the same file is resumed from its last confirmed part.
+// Once all the parts are staged, they are composed into key, see stager.
+func (a *Activity) uploadStaged(ctx context.Context, p, key, alg string, params *Params) (*Object, error) {
+	h := heartbeat.Start[Progress](ctx)
+	defer h.Stop()
+
+	// Keep recording the previous progress until the upload is resumed.
+	var prev Progress
+	if temporalsdk_activity.HasHeartbeatDetails(ctx) {
+		if err := temporalsdk_activity.GetHeartbeatDetails(ctx, &prev); err == nil {
+			h.Set(prev)
+		}
+	}
+
+	file, err := os.Open(p) // #nosec G304 -- trusted file path.
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+
+	opts, err := writerOptions(file, params)
+	if err != nil {
+		return nil, fmt.Errorf("detect content type: %w", err)
+	}
+
+	// Fail before staging the parts, the policy is applied again once the
+	// file checksums are known.
+	if params.Policy == PolicyFailIfExists {
+		if _, err := a.applyPolicy(ctx, key, nil, params.Policy); err != nil {
+			return nil, err
+		}
+	}
+
+	st, err := a.stager(ctx, key, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	fh := newFileHashes(alg)
+	progress, err := a.resume(ctx, st, prev, file, fi, key, params.PartSize, fh)
+	if err != nil {
+		return nil, err
+	}
+	h.Update(progress)
+
+	for progress.Offset < progress.FileSize {
+		number := progress.Parts + 1
+		size := progress.partSize(number)
+		if err := stagePart(ctx, st, progress, file, number, progress.Offset, size, fh); err != nil {
+			return nil, fmt.Errorf("upload part %d: %w", number, err)
+		}
+
+		progress.Parts = number
+		progress.Offset += size
+		if progress.MD5State, progress.DigestState, err = fh.state(); err != nil {
+			return nil, fmt.Errorf("upload part %d: %w", number, err)
+		}
+		h.Update(progress)
+	}
+
+	sums := fh.sums(progress.FileSize)
+	outcome, err := a.applyPolicy(ctx, key, sums, params.Policy)
+	if err != nil {
+		return nil, abandon(ctx, st, err)
+	}
+	if outcome == OutcomeSkipped {
+		if err := st.discard(ctx); err != nil {
+			return nil, fmt.Errorf("delete parts: %w", err)
+		}
+		return newObject(key, sums, opts, outcome), nil
+	}
+	opts.ContentMD5 = sums.md5
+	// Guard against the object being created after checking it.
+	opts.IfNotExist = params.Policy == PolicyFailIfExists
+
+	// The providers can't compose an object without parts.
+	composer := st
+	if progress.Parts == 0 {
+		composer = &objectStager{bucket: a.bucket, key: key}
+	}
+	if err := composer.compose(ctx, progress, opts); err != nil {
+		return nil, abandon(ctx, st, fmt.Errorf("compose parts: %w", existsError(err, key, opts)))
+	}
+	if err := a.verify(ctx, key, sums); err != nil {
+		return nil, fmt.Errorf("verify upload: %w", err)
+	}
+	if err := st.discard(ctx); err != nil {
+		return nil, fmt.Errorf("delete parts: %w", err)
+	}
+
+	return newObject(key, sums, opts, outcome), nil
+}
+
+// resume returns the progress of the previous upload prev if it's an upload of
+// the same file, keeping the parts that are still staged and restoring fh to
+// the progress offset. Otherwise, it discards the staged parts of any previous
+// upload to key and returns the progress of a new upload.
+func (a *Activity) resume(
+	ctx context.Context,
+	st stager,
+	prev Progress,
+	file *os.File,
+	fi os.FileInfo,
+	key string,
+	partSize int64,
+	fh *fileHashes,
+) (Progress, error) {
+	if prev.UploadID != "" &&
+		prev.Key == key &&
+		prev.FileSize == fi.Size() &&
+		prev.ModTime.Equal(fi.ModTime()) &&
+		prev.PartSize == partSize {
+		p := prev
+		confirmed, err := st.staged(ctx, p)
+		if err != nil && !errors.Is(err, errNoUpload) {
+			return Progress{}, fmt.Errorf("check parts: %w", err)
+		}
+		if err == nil {
+			p.Parts = confirmed
+			p.Offset = min(int64(confirmed)*partSize, p.FileSize)
+
+			if confirmed == prev.Parts && fh.restore(p.MD5State, p.DigestState) == nil {
+				return p, nil
+			}
+
+			// Hash the confirmed parts again if the saved hash states can't
+			// be used.
+			fh.reset()
+			if _, err := io.Copy(fh, io.NewSectionReader(file, 0, p.Offset)); err != nil {
+				return Progress{}, fmt.Errorf("checksum file: %w", err)
+			}
+			if p.MD5State, p.DigestState, err = fh.state(); err != nil {
+				return Progress{}, fmt.Errorf("checksum file: %w", err)
+			}
+
+			return p, nil
+		}
+	}
+
+	// The parts of uploads that can't be resumed are abandoned.
+	if err := st.discard(ctx); err != nil {
+		return Progress{}, fmt.Errorf("delete abandoned parts: %w", err)
+	}
+
+	p := Progress{
+		Key:      key,
+		FileSize: fi.Size(),
+		ModTime:  fi.ModTime(),
+		PartSize: partSize,
+	}
+	id, err := st.start(ctx, p)
+	if err != nil {
+		return Progress{}, fmt.Errorf("start upload: %w", err)
+	}
+	p.UploadID = id
+
+	return p, nil
+}
+
+// stagePart hashes the size bytes of file starting at offset, writing them to
+// fh, and stages them as the part number of the upload p with their MD5
+// checksum. The part is read twice, the second time usually from the
+// operating system cache, so the providers can check it and retry the
+// request.
+func stagePart(
+	ctx context.Context,
+	st stager,
+	p Progress,
+	file *os.File,
+	number int,
+	offset, size int64,
+	fh *fileHashes,
+) error {
+	r := io.NewSectionReader(file, offset, size)
+	md5h := md5.New() // #nosec G401 -- MD5 is used for the ContentMD5 integrity check.
+	if _, err := io.Copy(io.MultiWriter(md5h, fh), r); err != nil {
+		return fmt.Errorf("read file: %w", err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("read file: %w", err)
+	}
+
+	return st.stagePart(ctx, p, number, r, size, md5h.Sum(nil))
+}
+
+// abandon discards the staged parts of the upload if err means that the
+// upload can't be retried, and returns err.
+func abandon(ctx context.Context, st stager, err error) error {
+	if !errors.Is(err, ErrExists) {
+		return err
+	}
+	if derr := st.discard(ctx); derr != nil {
+		return errors.Join(err, fmt.Errorf("delete parts: %w", derr))
+	}
+
+	return err
+}
+
+// fileHashes generates the MD5 and digest of a file as its parts are staged.
+type fileHashes struct {
+	io.Writer
+	alg    string
+	md5    hash.Hash
+	digest hash.Hash
+}
+
+func newFileHashes(alg string) *fileHashes {
+	fh := &fileHashes{alg: alg}
+	fh.reset()
+
+	return fh
+}
+
+// reset discards the written data.
+func (fh *fileHashes) reset() {
+	fh.md5 = md5.New() // #nosec G401 -- MD5 is used for the ContentMD5 integrity check.
+	fh.digest = newDigestHash(fh.alg)
+	fh.Writer = io.MultiWriter(fh.md5, fh.digest)
+}
+
+// state returns the encoded states of the MD5 and digest hashes.
+func (fh *fileHashes) state() ([]byte, []byte, error) {
+	md5State, err := fh.md5.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, nil, fmt.Errorf("encode hash state: %v", err)
+	}
+	digestState, err := fh.digest.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, nil, fmt.Errorf("encode hash state: %v", err)
+	}
+
+	return md5State, digestState, nil
+}
+
+// restore restores the MD5 and digest hashes to the encoded states.
+func (fh *fileHashes) restore(md5State, digestState []byte) error {
+	if len(md5State) == 0 || len(digestState) == 0 {
+		return errors.New("missing hash state")
+	}
+	if err := fh.md5.(encoding.BinaryUnmarshaler).UnmarshalBinary(md5State); err != nil {
+		fh.reset()
+		return err
+	}
+	if err := fh.digest.(encoding.BinaryUnmarshaler).UnmarshalBinary(digestState); err != nil {
+		fh.reset()
+		return err
+	}
+
+	return nil
+}
+
+// sums returns the checksums of the size bytes written.
+func (fh *fileHashes) sums(size int64) *digests {
+	return &digests{size: size, md5: fh.md5.Sum(nil), digest: hex.EncodeToString(fh.digest.Sum(nil))}
+}
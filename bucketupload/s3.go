@@ -0,0 +1,191 @@
+package bucketupload
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"gocloud.dev/blob"
+)
+
+// s3MinPartSize and s3MaxParts are the limits of S3 multipart uploads, all the
+// parts but the last one must have at least s3MinPartSize bytes.
+const (
+	s3MinPartSize = 5 << 20
+	s3MaxParts    = 10_000
+)
+
+// s3Stager stages the parts of an upload to key in an S3 multipart upload,
+// completed in the bucket without reading the parts back.
+type s3Stager struct {
+	client *s3.Client
+	key    string
+
+	// in is the request the driver uses to write key, with the bucket name,
+	// the escaped key and the object attributes.
+	in *s3.PutObjectInput
+}
+
+func (s *s3Stager) start(ctx context.Context, p Progress) (string, error) {
+	if p.PartSize < s3MinPartSize && p.FileSize > p.PartSize {
+		return "", fmt.Errorf("PartSize must be at least %d bytes in S3 buckets", s3MinPartSize)
+	}
+	if p.FileSize > s3MaxParts*p.PartSize {
+		return "", fmt.Errorf("the file can't be uploaded to S3 in more than %d parts", s3MaxParts)
+	}
+
+	out, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:               s.in.Bucket,
+		Key:                  s.in.Key,
+		ACL:                  s.in.ACL,
+		CacheControl:         s.in.CacheControl,
+		ContentDisposition:   s.in.ContentDisposition,
+		ContentEncoding:      s.in.ContentEncoding,
+		ContentLanguage:      s.in.ContentLanguage,
+		ContentType:          s.in.ContentType,
+		Metadata:             s.in.Metadata,
+		ServerSideEncryption: s.in.ServerSideEncryption,
+		SSEKMSKeyId:          s.in.SSEKMSKeyId,
+		StorageClass:         s.in.StorageClass,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return aws.ToString(out.UploadId), nil
+}
+
+func (s *s3Stager) staged(ctx context.Context, p Progress) (int, error) {
+	parts, err := s.parts(ctx, p.UploadID)
+	if err != nil {
+		return 0, err
+	}
+
+	for number := 1; number <= p.Parts; number++ {
+		part, ok := parts[int32(number)] // #nosec G115 -- at most s3MaxParts.
+		if !ok || aws.ToInt64(part.Size) != p.partSize(number) {
+			return number - 1, nil
+		}
+	}
+
+	return p.Parts, nil
+}
+
+// parts returns the uploaded parts of the upload id by part number.
+func (s *s3Stager) parts(ctx context.Context, id string) (map[int32]types.Part, error) {
+	parts := make(map[int32]types.Part)
+	pages := s3.NewListPartsPaginator(s.client, &s3.ListPartsInput{
+		Bucket:   s.in.Bucket,
+		Key:      s.in.Key,
+		UploadId: aws.String(id),
+	})
+	for pages.HasMorePages() {
+		out, err := pages.NextPage(ctx)
+		if err != nil {
+			var nsu *types.NoSuchUpload
+			if errors.As(err, &nsu) {
+				return nil, errNoUpload
+			}
+			return nil, err
+		}
+		for _, part := range out.Parts {
+			parts[aws.ToInt32(part.PartNumber)] = part
+		}
+	}
+
+	return parts, nil
+}
+
+func (s *s3Stager) stagePart(
+	ctx context.Context,
+	p Progress,
+	number int,
+	r io.ReadSeeker,
+	size int64,
+	md5 []byte,
+) error {
+	_, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        s.in.Bucket,
+		Key:           s.in.Key,
+		UploadId:      aws.String(p.UploadID),
+		PartNumber:    aws.Int32(int32(number)), // #nosec G115 -- at most s3MaxParts.
+		Body:          r,
+		ContentLength: aws.Int64(size),
+		ContentMD5:    aws.String(base64.StdEncoding.EncodeToString(md5)),
+	})
+
+	return err
+}
+
+// compose completes the multipart upload. The object has no MD5 checksum, so
+// opts.ContentMD5 is not checked.
+func (s *s3Stager) compose(ctx context.Context, p Progress, opts *blob.WriterOptions) error {
+	parts, err := s.parts(ctx, p.UploadID)
+	if err != nil {
+		return err
+	}
+
+	completed := make([]types.CompletedPart, p.Parts)
+	for i := range completed {
+		part, ok := parts[int32(i+1)] // #nosec G115 -- at most s3MaxParts.
+		if !ok {
+			return fmt.Errorf("part %d not found", i+1)
+		}
+		completed[i] = types.CompletedPart{ETag: part.ETag, PartNumber: part.PartNumber}
+	}
+
+	in := &s3.CompleteMultipartUploadInput{
+		Bucket:          s.in.Bucket,
+		Key:             s.in.Key,
+		UploadId:        aws.String(p.UploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	}
+	if opts.IfNotExist {
+		in.IfNoneMatch = aws.String("*")
+	}
+	if _, err := s.client.CompleteMultipartUpload(ctx, in); err != nil {
+		var apiErr smithy.APIError
+		if opts.IfNotExist && errors.As(err, &apiErr) && apiErr.ErrorCode() == "PreconditionFailed" {
+			return fmt.Errorf("%w: %s", ErrExists, s.key)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// discard aborts the multipart uploads to the key.
+func (s *s3Stager) discard(ctx context.Context) error {
+	pages := s3.NewListMultipartUploadsPaginator(s.client, &s3.ListMultipartUploadsInput{
+		Bucket: s.in.Bucket,
+		Prefix: s.in.Key,
+	})
+	for pages.HasMorePages() {
+		out, err := pages.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, u := range out.Uploads {
+			if aws.ToString(u.Key) != aws.ToString(s.in.Key) {
+				continue
+			}
+			_, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   s.in.Bucket,
+				Key:      s.in.Key,
+				UploadId: u.UploadId,
+			})
+			var nsu *types.NoSuchUpload
+			if err != nil && !errors.As(err, &nsu) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
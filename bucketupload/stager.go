@@ -0,0 +1,235 @@
+package bucketupload
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// stager stages the parts of an upload to a key and composes them into the
+// object.
+type stager interface {
+	// start returns the ID of a new upload with the progress p.
+	start(ctx context.Context, p Progress) (string, error)
+
+	// staged returns the number of parts of the upload p, counted in order
+	// from the first one, that are staged with their expected size. It
+	// returns errNoUpload if the upload doesn't exist anymore.
+	staged(ctx context.Context, p Progress) (int, error)
+
+	// stagePart stages the part number of the upload p with the size bytes
+	// read from r, checked against their MD5 checksum.
+	stagePart(ctx context.Context, p Progress, number int, r io.ReadSeeker, size int64, md5 []byte) error
+
+	// compose writes the object from the p.Parts staged parts of the upload
+	// p, using opts.
+	compose(ctx context.Context, p Progress, opts *blob.WriterOptions) error
+
+	// discard deletes the staged parts of all the uploads to the key.
+	discard(ctx context.Context) error
+}
+
+// errNoUpload means that a staged upload doesn't exist anymore.
+var errNoUpload = errors.New("upload not found")
+
+// errCaptured stops a write once the driver values have been captured.
+var errCaptured = errors.New("driver values captured")
+
+// stager returns the stager of the uploads to key written with opts. It uses
+// the native multipart upload of S3, GCS and Azure buckets, and stages the
+// parts as objects with any other driver, or if the Azure driver escapes key.
+func (a *Activity) stager(ctx context.Context, key string, opts *blob.WriterOptions) (stager, error) {
+	var (
+		s3Client    *s3.Client
+		gcsClient   *storage.Client
+		azureClient *container.Client
+	)
+	switch {
+	case a.bucket.As(&s3Client):
+		s := &s3Stager{client: s3Client, key: key}
+		if err := a.captureWrite(ctx, key, opts, func(as func(any) bool) bool { return as(&s.in) }); err != nil {
+			return nil, err
+		}
+		return s, nil
+	case a.bucket.As(&gcsClient):
+		var obj **storage.ObjectHandle
+		if err := a.captureWrite(ctx, key, opts, func(as func(any) bool) bool { return as(&obj) }); err != nil {
+			return nil, err
+		}
+		return &gcsStager{
+			objectStager: &objectStager{bucket: a.bucket, key: key},
+			bucket:       gcsClient.Bucket((*obj).BucketName()),
+			name:         (*obj).ObjectName(),
+		}, nil
+	case a.bucket.As(&azureClient) && !azureEscapes(key):
+		s := &azureStager{client: azureClient.NewBlockBlobClient(key), key: key}
+		if err := a.captureWrite(ctx, key, opts, func(as func(any) bool) bool { return as(&s.upload) }); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+
+	return &objectStager{bucket: a.bucket, key: key}, nil
+}
+
+// captureWrite calls capture with the As function of a write to key with
+// opts, before the driver writes anything, and discards the write. capture
+// returns false if the driver values are not available.
+func (a *Activity) captureWrite(
+	ctx context.Context,
+	key string,
+	opts *blob.WriterOptions,
+	capture func(as func(any) bool) bool,
+) error {
+	// Cancelling the writer context discards the object on Close.
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	o := *opts
+	o.BeforeWrite = func(as func(any) bool) error {
+		if !capture(as) {
+			return errors.New("driver values not available")
+		}
+		return errCaptured
+	}
+	w, err := a.bucket.NewWriter(wctx, key, &o)
+	if errors.Is(err, errCaptured) {
+		return nil
+	}
+	if err == nil {
+		cancel()
+		_ = w.Close()
+		err = errors.New("driver values not available")
+	}
+
+	return fmt.Errorf("check bucket driver: %w", err)
+}
+
+// objectStager stages the parts of an upload to key as objects, with the
+// generic bucket API, so it works with any driver. Composing the object reads
+// the parts back through the worker, it's the last resort for the drivers
+// without a native multipart upload.
+type objectStager struct {
+	bucket *blob.Bucket
+	key    string
+}
+
+func (s *objectStager) start(context.Context, Progress) (string, error) {
+	return rand.Text(), nil
+}
+
+func (s *objectStager) staged(ctx context.Context, p Progress) (int, error) {
+	for number := 1; number <= p.Parts; number++ {
+		attrs, err := s.bucket.Attributes(ctx, partKey(s.key, p.UploadID, number))
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return number - 1, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("check part %d: %w", number, err)
+		}
+		if attrs.Size != p.partSize(number) {
+			return number - 1, nil
+		}
+	}
+
+	return p.Parts, nil
+}
+
+func (s *objectStager) stagePart(
+	ctx context.Context,
+	p Progress,
+	number int,
+	r io.ReadSeeker,
+	_ int64,
+	md5 []byte,
+) error {
+	// Cancelling the writer context discards the part on Close.
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w, err := s.bucket.NewWriter(wctx, partKey(s.key, p.UploadID, number), &blob.WriterOptions{
+		ContentType: defaultContentType,
+		ContentMD5:  md5,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		cancel()
+		_ = w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+// compose copies the staged parts in order to the key through a single
+// writer. The object isn't written if any of the parts can't be copied.
+func (s *objectStager) compose(ctx context.Context, p Progress, opts *blob.WriterOptions) error {
+	// Cancelling the writer context discards the object on Close.
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w, err := s.bucket.NewWriter(wctx, s.key, opts)
+	if err != nil {
+		return err
+	}
+
+	for number := 1; number <= p.Parts; number++ {
+		if err := s.copyPart(ctx, w, partKey(s.key, p.UploadID, number)); err != nil {
+			cancel()
+			_ = w.Close()
+			return fmt.Errorf("copy part %d: %w", number, err)
+		}
+	}
+
+	return w.Close()
+}
+
+func (s *objectStager) copyPart(ctx context.Context, w io.Writer, key string) error {
+	r, err := s.bucket.NewReader(ctx, key, nil)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (s *objectStager) discard(ctx context.Context) error {
+	iter := s.bucket.List(&blob.ListOptions{Prefix: partsPrefix(s.key)})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.bucket.Delete(ctx, obj.Key); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			return err
+		}
+	}
+}
+
+// azureEscapes returns true if the Azure driver escapes key, so the blob name
+// isn't the key.
+func azureEscapes(key string) bool {
+	for _, c := range key {
+		if c < 32 || c == 127 || strings.ContainsRune(`"#%?\`, c) {
+			return true
+		}
+	}
+
+	return strings.HasSuffix(key, "/") || strings.Contains(key, "../")
+}
@@ -0,0 +1,108 @@
+package heartbeat
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	temporalsdk_activity "go.temporal.io/sdk/activity"
+)
+
+// DefaultInterval is the heartbeat interval used when the activity doesn't
+// have a heartbeat timeout.
+const DefaultInterval = 10 * time.Second
+
+// Heartbeat records heartbeats for an activity at a regular interval, like
+// temporal.StartAutoHeartbeat, using the latest progress as the heartbeat
+// details. No details are recorded until the progress is set.
+//
+// The Temporal SDK throttles the recorded heartbeats: it sends the first one
+// right away and then at most one per throttle interval (0.8 times the
+// heartbeat timeout, or 30 seconds by default), with the details of the latest
+// heartbeat recorded in the interval. The details recorded right before the
+// activity completes or fails may never be sent, so the details of a retried
+// activity may be older than the progress it last recorded.
+type Heartbeat[T any] struct {
+	ctx      context.Context
+	mu       sync.Mutex
+	progress T
+	set      bool
+	pending  bool
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Start starts recording heartbeats for the activity in ctx, each one-third of
+// the activity heartbeat timeout or every DefaultInterval if there is no
+// timeout. The heartbeats stop when Stop is called or ctx is done.
+func Start[T any](ctx context.Context) *Heartbeat[T] {
+	interval := temporalsdk_activity.GetInfo(ctx).HeartbeatTimeout / 3
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	h := &Heartbeat[T]{ctx: ctx, done: make(chan struct{})}
+	h.wg.Go(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				h.record()
+			case <-ctx.Done():
+				return
+			case <-h.done:
+				return
+			}
+		}
+	})
+
+	return h
+}
+
+// Set sets the progress recorded by the next heartbeat. The progress is
+// recorded concurrently, so the caller must not modify the values it shares
+// with it (e.g. slice elements) after it's set, appending to a slice is safe.
+func (h *Heartbeat[T]) Set(p T) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.progress = p
+	h.set = true
+	h.pending = true
+}
+
+// Update sets the progress and records a heartbeat with it right away, without
+// waiting for the next interval. The SDK may still delay sending it, see
+// Heartbeat.
+func (h *Heartbeat[T]) Update(p T) {
+	h.Set(p)
+	h.record()
+}
+
+// Stop stops the heartbeats, recording the latest progress if it was set
+// after the last heartbeat. The SDK may not send it, see Heartbeat.
+func (h *Heartbeat[T]) Stop() {
+	close(h.done)
+	h.wg.Wait()
+
+	h.mu.Lock()
+	pending := h.pending
+	h.mu.Unlock()
+	if pending {
+		h.record()
+	}
+}
+
+func (h *Heartbeat[T]) record() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.pending = false
+	if !h.set {
+		temporalsdk_activity.RecordHeartbeat(h.ctx)
+		return
+	}
+	temporalsdk_activity.RecordHeartbeat(h.ctx, h.progress)
+}
@@ -0,0 +1,96 @@
+package heartbeat_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	temporalsdk_activity "go.temporal.io/sdk/activity"
+	temporalsdk_converter "go.temporal.io/sdk/converter"
+	temporalsdk_testsuite "go.temporal.io/sdk/testsuite"
+	"gotest.tools/v3/assert"
+
+	"github.com/artefactual-sdps/temporal-activities/internal/heartbeat"
+)
+
+type progress struct {
+	Done int
+}
+
+func TestHeartbeat(t *testing.T) {
+	t.Parallel()
+
+	type test struct {
+		name    string
+		updates func(h *heartbeat.Heartbeat[progress])
+		want    []progress
+	}
+	for _, tt := range []test{
+		{
+			name:    "Doesn't record details until the progress is set",
+			updates: func(h *heartbeat.Heartbeat[progress]) {},
+		},
+		{
+			// The SDK holds back the following heartbeats until the end of the
+			// throttle interval, after the activity completes.
+			name: "Records the first update right away",
+			updates: func(h *heartbeat.Heartbeat[progress]) {
+				h.Update(progress{Done: 1})
+				h.Update(progress{Done: 2})
+			},
+			want: []progress{{Done: 1}},
+		},
+		{
+			name: "Records the latest progress set when stopped",
+			updates: func(h *heartbeat.Heartbeat[progress]) {
+				h.Set(progress{Done: 1})
+				h.Set(progress{Done: 2})
+			},
+			want: []progress{{Done: 2}},
+		},
+		{
+			name: "Doesn't record an already recorded progress when stopped",
+			updates: func(h *heartbeat.Heartbeat[progress]) {
+				h.Update(progress{Done: 1})
+			},
+			want: []progress{{Done: 1}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				mu  sync.Mutex
+				got []progress
+			)
+			ts := &temporalsdk_testsuite.WorkflowTestSuite{}
+			env := ts.NewTestActivityEnvironment()
+			env.SetOnActivityHeartbeatListener(
+				func(_ *temporalsdk_activity.Info, details temporalsdk_converter.EncodedValues) {
+					if !details.HasValues() {
+						return
+					}
+					var p progress
+					_ = details.Get(&p)
+
+					mu.Lock()
+					defer mu.Unlock()
+					got = append(got, p)
+				},
+			)
+			env.RegisterActivityWithOptions(
+				func(ctx context.Context) error {
+					h := heartbeat.Start[progress](ctx)
+					tt.updates(h)
+					h.Stop()
+					return nil
+				},
+				temporalsdk_activity.RegisterOptions{Name: "heartbeat"},
+			)
+
+			_, err := env.ExecuteActivity("heartbeat")
+			assert.NilError(t, err)
+			assert.DeepEqual(t, got, tt.want)
+		})
+	}
+}